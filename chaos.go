@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const (
+	deletePod        = "DELETE_POD"
+	restartContainer = "RESTART_CONTAINER"
+	pauseProcess     = "PAUSE"
+	resumeProcess    = "RESUME"
+	killProcess      = "KILL"
+)
+
+/* How long to wait for a restarted container to run commands again */
+var containerRestartTimeout = 2 * time.Minute
+
+var processNameRegex = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+var signalRegex = regexp.MustCompile(`^[A-Z0-9]+$`)
+
+// Chaos injects a fault into the selected nodes instead of running a
+// command.  Nodes whose pods are deleted get the pods that replace them.
+type Chaos struct {
+	Action    string         `yaml:"action"`    /* DELETE_POD, RESTART_CONTAINER, PAUSE, RESUME or KILL */
+	Process   string         `yaml:"process"`   /* Process name for PAUSE, RESUME and KILL */
+	Signal    string         `yaml:"signal"`    /* Signal for KILL, default KILL */
+	Container string         `yaml:"container"` /* Container for RESTART_CONTAINER, default the first */
+	Schedule  *ChaosSchedule `yaml:"schedule"`
+}
+
+// ChaosSchedule repeats a chaos action at random intervals, on nodes
+// selected again each time, until the duration is over.  The step blocks
+// for the whole duration.
+type ChaosSchedule struct {
+	Duration    int `yaml:"duration"`     /* Seconds */
+	MinInterval int `yaml:"min_interval"` /* Seconds between actions */
+	MaxInterval int `yaml:"max_interval"`
+}
+
+func (chaos *Chaos) validate() error {
+	switch chaos.Action {
+	case deletePod, restartContainer:
+		if chaos.Process != "" {
+			return fmt.Errorf("%s does not take a process", chaos.Action)
+		}
+	case pauseProcess, resumeProcess, killProcess:
+		if !processNameRegex.MatchString(chaos.Process) {
+			return fmt.Errorf("%s needs a process name", chaos.Action)
+		}
+	default:
+		return fmt.Errorf("Invalid chaos action %s", chaos.Action)
+	}
+	if chaos.Signal != "" && (chaos.Action != killProcess || !signalRegex.MatchString(chaos.Signal)) {
+		return errors.New("Signal is only valid for KILL, as a name like TERM or a number")
+	}
+	if chaos.Container != "" && chaos.Action != restartContainer {
+		return errors.New("Container is only valid for RESTART_CONTAINER")
+	}
+	if chaos.Container != "" && !processNameRegex.MatchString(chaos.Container) {
+		return fmt.Errorf("Invalid container name %s", chaos.Container)
+	}
+	if schedule := chaos.Schedule; schedule != nil {
+		if chaos.Action == resumeProcess {
+			return errors.New("RESUME can't be scheduled")
+		}
+		if schedule.Duration <= 0 || schedule.MinInterval < 0 || schedule.MaxInterval <= 0 ||
+			schedule.MinInterval > schedule.MaxInterval {
+			return errors.New("Schedule needs a positive duration and 0 <= min_interval <= max_interval, max_interval > 0")
+		}
+		if schedule.MinInterval > schedule.Duration {
+			return errors.New("Schedule min_interval is longer than its duration, no action would run")
+		}
+	}
+	return nil
+}
+
+// String describes the action for logs and reports
+func (chaos *Chaos) String() string {
+	description := chaos.Action
+	if chaos.Process != "" {
+		description += " " + chaos.Process
+	}
+	if chaos.Action == killProcess {
+		description += " with SIG" + chaos.signal()
+	}
+	if chaos.Container != "" {
+		description += " container " + chaos.Container
+	}
+	if schedule := chaos.Schedule; schedule != nil {
+		description += fmt.Sprintf(" every %d-%ds for %ds", schedule.MinInterval, schedule.MaxInterval, schedule.Duration)
+	}
+	return description
+}
+
+func (chaos *Chaos) signal() string {
+	if chaos.Signal == "" {
+		return "KILL"
+	}
+	return chaos.Signal
+}
+
+// command returns the command run in the pods for process actions
+func (chaos *Chaos) command() string {
+	switch chaos.Action {
+	case pauseProcess:
+		return "killall -STOP " + chaos.Process
+	case resumeProcess:
+		return "killall -CONT " + chaos.Process
+	case killProcess:
+		return "killall -" + chaos.signal() + " " + chaos.Process
+	}
+	return ""
+}
+
+// pausedProcesses are the processes paused by steps, resumed by RESUME
+// steps, before teardown or on exit
+type pausedProcesses struct {
+	pods      *GetPodsOutput
+	processes map[int][]string /* By node */
+	resume    func()
+	failures  int
+}
+
+// paused is nil while no process is paused
+var paused *pausedProcesses
+
+func pauseOn(pods *GetPodsOutput, node int, process string) {
+	if paused == nil {
+		p := &pausedProcesses{pods: pods, processes: make(map[int][]string)}
+		p.resume = pushCleanup("resume paused processes", func() {
+			p.failures = resumeAll(p.pods, p.processes)
+		})
+		paused = p
+	}
+	if !containsString(paused.processes[node], process) {
+		paused.processes[node] = append(paused.processes[node], process)
+	}
+}
+
+func resumedOn(node int, process string) {
+	if paused == nil {
+		return
+	}
+	remaining := make([]string, 0)
+	for _, name := range paused.processes[node] {
+		if name != process {
+			remaining = append(remaining, name)
+		}
+	}
+	paused.processes[node] = remaining
+}
+
+// resumePausedProcesses resumes every paused process and returns the
+// number of pods it failed on
+func resumePausedProcesses() int {
+	if paused == nil {
+		return 0
+	}
+	p := paused
+	paused = nil
+	p.resume()
+	return p.failures
+}
+
+func resumeAll(pods *GetPodsOutput, processes map[int][]string) int {
+	nodes := make([]int, 0)
+	commands := make(map[int]string)
+	for node, names := range processes {
+		if len(names) == 0 {
+			continue
+		}
+		nodes = append(nodes, node)
+		commands[node] = "killall -CONT " + strings.Join(names, " ")
+	}
+	if len(nodes) == 0 {
+		return 0
+	}
+	sort.Ints(nodes)
+	color.Cyan("### Resuming paused processes on nodes %v", nodes)
+	_, errs := runOnPods(*pods, nodes, commands, 0)
+	for _, err := range errs {
+		color.Red("Failed to resume processes: %s", err)
+	}
+	return len(errs)
+}
+
+// restoreCluster heals the network and resumes paused processes, so that
+// teardown and later tests find the cluster whole.  It returns the number
+// of pods it failed on.
+func restoreCluster() int {
+	return healAlteredNetwork() + resumePausedProcesses()
+}
+
+// handleChaos applies a chaos action to the selected nodes, or with a
+// schedule, applies it at random intervals on new selections.  Intervals
+// are cut short to the end of the schedule, so the action runs at least
+// once.
+func handleChaos(pods *GetPodsOutput, step *Step, summary *Summary, config Config, subsetPartition map[int][]int, envArrays map[string][]string, nodeIndices []int, phase string) {
+	schedule := step.Chaos.Schedule
+	if schedule == nil {
+		applyChaos(pods, step, summary, config, nodeIndices, phase)
+		return
+	}
+	color.Cyan("### Chaos for %d seconds: %s", schedule.Duration, step.Chaos)
+	deadline := time.Now().Add(time.Duration(schedule.Duration) * time.Second)
+	var previous []int
+	for {
+		interval, ok := nextChaosInterval(schedule, time.Until(deadline))
+		if !ok {
+			break
+		}
+		time.Sleep(interval)
+		if step.Chaos.Action == pauseProcess && previous != nil {
+			/* Paused processes come back when others are paused */
+			resume := *step
+			resume.Chaos = &Chaos{Action: resumeProcess, Process: step.Chaos.Process}
+			applyChaos(pods, &resume, summary, config, previous, phase)
+		}
+		applyChaos(pods, step, summary, config, nodeIndices, phase)
+		previous = nodeIndices
+		nodeIndices = selectNodes(*step, config, subsetPartition, pods, envArrays)
+	}
+	if step.Chaos.Action == pauseProcess && previous != nil {
+		resume := *step
+		resume.Chaos = &Chaos{Action: resumeProcess, Process: step.Chaos.Process}
+		applyChaos(pods, &resume, summary, config, previous, phase)
+	}
+}
+
+// nextChaosInterval picks the time until the next action of a schedule,
+// at most remaining, or returns false if min_interval doesn't fit in it
+func nextChaosInterval(schedule *ChaosSchedule, remaining time.Duration) (time.Duration, bool) {
+	shortest := time.Duration(schedule.MinInterval) * time.Second
+	if remaining < shortest {
+		return 0, false
+	}
+	interval := time.Duration(schedule.MinInterval+selectionRand.Intn(schedule.MaxInterval-schedule.MinInterval+1)) * time.Second
+	if interval > remaining {
+		interval = remaining
+	}
+	return interval, true
+}
+
+func applyChaos(pods *GetPodsOutput, step *Step, summary *Summary, config Config, nodeIndices []int, phase string) {
+	chaos := step.Chaos
+	color.Cyan("### Chaos on nodes %v: %s", nodeIndices, chaos.Action+" "+chaos.Process)
+	summary.addEvent(phase, step.Name, strings.ToLower(chaos.Action), nodeIndices, chaos.String())
+	var errs []error
+	switch chaos.Action {
+	case deletePod:
+		for _, node := range nodeIndices {
+			if err := kubectl("delete", "pod", pods.Items[node-1].Metadata.Name, "--wait=false"); err != nil {
+				errs = append(errs, fmt.Errorf("node %d: %s", node, err))
+			}
+		}
+		replacements, err := refreshPods(&config, pods)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		recordReplacements(summary, phase, step.Name, replacements)
+	case restartContainer:
+		errs = restartContainers(pods, nodeIndices, chaos.Container)
+	default:
+		commands := make(map[int]string)
+		for _, node := range nodeIndices {
+			commands[node] = chaos.command()
+			switch chaos.Action {
+			case pauseProcess:
+				pauseOn(pods, node, chaos.Process)
+			case resumeProcess:
+				resumedOn(node, chaos.Process)
+			}
+		}
+		var timeouts int
+		timeouts, errs = runOnPods(*pods, nodeIndices, commands, step.Timeout)
+		summary.Timeouts += timeouts
+	}
+	for _, err := range errs {
+		color.Red("Chaos action failed: %s", err)
+		summary.Failures++
+	}
+}
+
+// restartCommand kills the main process of a container.  Signals sent
+// to PID 1 from inside its namespace are ignored unless it handles them,
+// so its children, like the daemon started by an init such as tini, are
+// killed, and PID 1 itself is only sent TERM.
+const restartCommand = "kill -s KILL $(cat /proc/1/task/1/children) 2>/dev/null; kill -s TERM 1"
+
+// restartContainers kills the main process of a container of each pod,
+// so that kubernetes restarts it, and waits until its restart count goes
+// up and it runs commands again
+func restartContainers(pods *GetPodsOutput, nodeIndices []int, container string) []error {
+	errs := make([]error, 0)
+	restarts := make(map[int]int)
+	for _, node := range nodeIndices {
+		pod := pods.Items[node-1]
+		name := containerName(pod, container)
+		count, err := restartCount(pod.Metadata.Name, name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("node %d: %s", node, err))
+			continue
+		}
+		restarts[node] = count
+		/* The exec dies with the container, so its error is expected */
+		kubectl(execArgs(pod.Metadata.Name, name, "sh", "-c", restartCommand)...)
+	}
+	for _, node := range nodeIndices {
+		before, ok := restarts[node]
+		if !ok {
+			continue
+		}
+		pod := pods.Items[node-1]
+		name := containerName(pod, container)
+		deadline := time.Now().Add(containerRestartTimeout)
+		for {
+			time.Sleep(2 * time.Second)
+			count, err := restartCount(pod.Metadata.Name, name)
+			if err == nil && count > before && kubectl(execArgs(pod.Metadata.Name, name, "true")...) == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				errs = append(errs, fmt.Errorf("node %d: container %s of %s was not restarted within %s", node, name, pod.Metadata.Name, containerRestartTimeout))
+				break
+			}
+		}
+	}
+	return errs
+}
+
+// containerName returns container, or the first container of the pod
+func containerName(pod Pod, container string) string {
+	if container == "" && len(pod.Spec.Containers) != 0 {
+		return pod.Spec.Containers[0].Name
+	}
+	return container
+}
+
+// execArgs are the kubectl arguments to run command in a container of a
+// pod, or in its default container
+func execArgs(pod string, container string, command ...string) []string {
+	args := []string{"exec", pod}
+	if container != "" {
+		args = append(args, "-c", container)
+	}
+	return append(append(args, "--"), command...)
+}
+
+// restartCount returns the number of times kubernetes restarted a
+// container of a pod, or its first container
+func restartCount(pod string, container string) (int, error) {
+	status := "[0]"
+	if container != "" {
+		status = `[?(@.name=="` + container + `")]`
+	}
+	out, err := kubectlOutput("get", "pod", pod, "-o", "jsonpath={.status.containerStatuses"+status+".restartCount}")
+	if err != nil {
+		return 0, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		return 0, fmt.Errorf("no restart count for container %s of %s", container, pod)
+	}
+	return count, nil
+}
+
+// kubectl runs kubectl with args and returns its error output on failure
+func kubectl(args ...string) error {
+	_, err := kubectlOutput(args...)
+	return err
+}
+
+// kubectlOutput runs kubectl with args and returns its output
+func kubectlOutput(args ...string) (string, error) {
+	cmd := exec.Command("kubectl", args...)
+	var out, errout bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &errout
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %s", err, strings.TrimSpace(errout.String()))
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateChaos(t *testing.T) {
+	for _, c := range []struct {
+		chaos   Chaos
+		message string
+	}{
+		{Chaos{Action: deletePod}, ""},
+		{Chaos{Action: restartContainer, Container: "ipfs"}, ""},
+		{Chaos{Action: killProcess, Process: "ipfs-cluster-service", Signal: "TERM"}, ""},
+		{Chaos{Action: pauseProcess, Process: "ipfs", Schedule: &ChaosSchedule{Duration: 60, MinInterval: 5, MaxInterval: 10}}, ""},
+		{Chaos{Action: "EXPLODE"}, "Invalid chaos action"},
+		{Chaos{Action: pauseProcess}, "needs a process name"},
+		{Chaos{Action: killProcess, Process: "ipfs; rm -rf /"}, "needs a process name"},
+		{Chaos{Action: deletePod, Process: "ipfs"}, "does not take a process"},
+		{Chaos{Action: pauseProcess, Process: "ipfs", Signal: "TERM"}, "only valid for KILL"},
+		{Chaos{Action: killProcess, Process: "ipfs", Signal: "term"}, "only valid for KILL"},
+		{Chaos{Action: deletePod, Container: "ipfs"}, "only valid for RESTART_CONTAINER"},
+		{Chaos{Action: resumeProcess, Process: "ipfs", Schedule: &ChaosSchedule{Duration: 1, MaxInterval: 1}}, "can't be scheduled"},
+		{Chaos{Action: deletePod, Schedule: &ChaosSchedule{Duration: 60, MinInterval: 10, MaxInterval: 5}}, "min_interval <= max_interval"},
+		{Chaos{Action: deletePod, Schedule: &ChaosSchedule{MaxInterval: 5}}, "positive duration"},
+		{Chaos{Action: deletePod, Schedule: &ChaosSchedule{Duration: 5, MinInterval: 10, MaxInterval: 20}}, "no action would run"},
+		{Chaos{Action: restartContainer, Container: "ipfs'"}, "Invalid container name"},
+	} {
+		err := c.chaos.validate()
+		checkError(t, c.chaos, err, c.message)
+	}
+}
+
+func TestChaosCommands(t *testing.T) {
+	kill := &Chaos{Action: killProcess, Process: "ipfs"}
+	if kill.command() != "killall -KILL ipfs" || kill.String() != "KILL ipfs with SIGKILL" {
+		t.Errorf("Unexpected kill: %s, %s", kill.command(), kill)
+	}
+	kill.Signal = "TERM"
+	if kill.command() != "killall -TERM ipfs" {
+		t.Errorf("Unexpected kill: %s", kill.command())
+	}
+	pause := &Chaos{Action: pauseProcess, Process: "ipfs", Schedule: &ChaosSchedule{Duration: 60, MinInterval: 5, MaxInterval: 10}}
+	if pause.command() != "killall -STOP ipfs" || pause.String() != "PAUSE ipfs every 5-10s for 60s" {
+		t.Errorf("Unexpected pause: %s, %s", pause.command(), pause)
+	}
+	if (&Chaos{Action: resumeProcess, Process: "ipfs"}).command() != "killall -CONT ipfs" {
+		t.Error("Unexpected resume command")
+	}
+}
+
+// test that scheduled intervals stay within the schedule, so that the
+// first action always runs
+func TestNextChaosInterval(t *testing.T) {
+	schedule := &ChaosSchedule{Duration: 30, MinInterval: 5, MaxInterval: 60}
+	for i := 0; i < 20; i++ {
+		interval, ok := nextChaosInterval(schedule, 30*time.Second)
+		if !ok || interval < 5*time.Second || interval > 30*time.Second {
+			t.Fatalf("Unexpected interval %s, %t", interval, ok)
+		}
+	}
+	if _, ok := nextChaosInterval(schedule, 4*time.Second); ok {
+		t.Error("Expected no interval shorter than min_interval")
+	}
+}
+
+func TestRestartArgs(t *testing.T) {
+	pod := Pod{}
+	if name := containerName(pod, ""); name != "" {
+		t.Errorf("Unexpected container %q", name)
+	}
+	pod.Spec.Containers = append(pod.Spec.Containers, struct {
+		Name  string `json:"name"`
+		Image string `json:"image"`
+	}{"ipfs", "ipfs/go-ipfs"})
+	if name := containerName(pod, ""); name != "ipfs" {
+		t.Errorf("Expected the first container, got %q", name)
+	}
+	if args := strings.Join(execArgs("pod-1", "ipfs", "true"), " "); args != "exec pod-1 -c ipfs -- true" {
+		t.Errorf("Unexpected args %s", args)
+	}
+	if args := strings.Join(execArgs("pod-1", "", "true"), " "); args != "exec pod-1 -- true" {
+		t.Errorf("Unexpected args %s", args)
+	}
+}
+
+// test that paused processes are tracked until resumed
+func TestPausedProcesses(t *testing.T) {
+	pods := &GetPodsOutput{}
+	pauseOn(pods, 1, "ipfs")
+	pauseOn(pods, 1, "ipfs")
+	pauseOn(pods, 2, "ipfs-cluster-service")
+	resumedOn(2, "ipfs-cluster-service")
+	if len(paused.processes[1]) != 1 || len(paused.processes[2]) != 0 {
+		t.Fatalf("Unexpected paused processes: %v", paused.processes)
+	}
+	/* Without processes left to resume, no pod is contacted */
+	paused.processes[1] = nil
+	if failures := resumePausedProcesses(); failures != 0 || paused != nil {
+		t.Fatalf("Expected nothing to resume, got %d failures", failures)
+	}
+}
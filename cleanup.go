@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/fatih/color"
+)
+
+// cleanup is a piece of work that has to happen before the process
+// exits, whether the test finished normally, hit a fatal error or was
+// interrupted.  Teardown phases register themselves here so that nodes
+// are never left SIGSTOPped or firewalled for the next test.
+type cleanup struct {
+	name string
+	fn   func()
+	ran  bool
+}
+
+var cleanupMu sync.Mutex
+var cleanups []*cleanup
+
+// pushCleanup registers fn to be run on exit and returns a function
+// that runs it right away (at most once) and unregisters it
+func pushCleanup(name string, fn func()) func() {
+	c := &cleanup{name: name, fn: fn}
+	cleanupMu.Lock()
+	cleanups = append(cleanups, c)
+	cleanupMu.Unlock()
+	return func() {
+		runCleanup(c)
+	}
+}
+
+func runCleanup(c *cleanup) {
+	cleanupMu.Lock()
+	for i, pending := range cleanups {
+		if pending == c {
+			cleanups = append(cleanups[:i], cleanups[i+1:]...)
+			break
+		}
+	}
+	/* Mark before running so that a fatal error inside the cleanup
+	   itself does not run it a second time */
+	alreadyRan := c.ran
+	c.ran = true
+	cleanupMu.Unlock()
+	if !alreadyRan {
+		c.fn()
+	}
+}
+
+// runCleanups runs every pending cleanup, most recently registered first
+func runCleanups() {
+	for {
+		cleanupMu.Lock()
+		if len(cleanups) == 0 {
+			cleanupMu.Unlock()
+			return
+		}
+		c := cleanups[len(cleanups)-1]
+		cleanupMu.Unlock()
+		color.Yellow("## Running %s before exit", c.name)
+		runCleanup(c)
+	}
+}
+
+// errInterrupted stops a test after Ctrl-C or SIGTERM
+var errInterrupted = errors.New("interrupted")
+
+// interrupted is closed when the process gets Ctrl-C or SIGTERM
+var interrupted = make(chan struct{})
+
+// checkInterrupted returns errInterrupted once the process was interrupted
+func checkInterrupted() error {
+	select {
+	case <-interrupted:
+		return errInterrupted
+	default:
+		return nil
+	}
+}
+
+// handleInterrupts makes Ctrl-C and SIGTERM stop the test before its next
+// step, so that teardown and finally still run on the main goroutine
+// before the process exits.  A second signal exits right away.
+func handleInterrupts() {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		fmt.Fprintf(os.Stderr, "\nReceived %s, cleaning up after the current step...\n", sig)
+		close(interrupted)
+		sig = <-sigs
+		fmt.Fprintf(os.Stderr, "\nReceived %s again, exiting without cleaning up\n", sig)
+		os.Exit(130)
+	}()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+)
+
+// test that pending cleanups run newest first and only once
+func TestCleanupOrder(t *testing.T) {
+	var ran []string
+	firstDone := pushCleanup("first", func() { ran = append(ran, "first") })
+	pushCleanup("second", func() { ran = append(ran, "second") })
+	thirdDone := pushCleanup("third", func() { ran = append(ran, "third") })
+
+	thirdDone()
+	runCleanups()
+	firstDone()
+	runCleanups()
+
+	if !stringSlicesEqual(ran, []string{"third", "second", "first"}) {
+		t.Fatalf("Cleanups ran in the wrong order or more than once: %v", ran)
+	}
+}
+
+// test that a cleanup which triggers runCleanups itself does not run twice
+func TestCleanupReentrant(t *testing.T) {
+	count := 0
+	pushCleanup("reentrant", func() {
+		count++
+		runCleanups()
+	})
+	runCleanups()
+	if count != 1 {
+		t.Fatalf("Cleanup ran %d times, expected once", count)
+	}
+}
+
+func stringSlicesEqual(s1, s2 []string) bool {
+	if len(s1) != len(s2) {
+		return false
+	}
+	for j := range s1 {
+		if s1[j] != s2[j] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+const (
+	uniform    = "UNIFORM"
+	logUniform = "LOG_UNIFORM"
+)
+
+const defaultGenerateRoot = "/tmp/kipfs-data"
+
+// Generate is a step type that creates a tree of files inside the
+// selected pods.  The files are generated by kubernetes-ipfs from Seed
+// and streamed into each pod, so the same seed always produces the same
+// tree and the expected content hashes are known without reading it back.
+//
+//	generate:
+//	  root: /tmp/archive
+//	  files: 1000
+//	  min_size: 10KB
+//	  max_size: 1MB
+//	  distribution: LOG_UNIFORM
+//	  depth: 10
+//	  entropy: 0.5
+//	  seed: 42
+//	  save_root: ROOT
+//	  save_hashes: HASHES
+type Generate struct {
+	Root         string   `yaml:"root"`         /* Directory to create, defaults to /tmp/kipfs-data */
+	Files        int      `yaml:"files"`        /* Number of files */
+	MinSize      string   `yaml:"min_size"`     /* e.g. 500B, 10KB, 1MB */
+	MaxSize      string   `yaml:"max_size"`     /* Defaults to MinSize */
+	Distribution string   `yaml:"distribution"` /* UNIFORM (default) or LOG_UNIFORM file sizes */
+	Depth        int      `yaml:"depth"`        /* Directory nesting depth, 1 puts every file in root */
+	Entropy      *float64 `yaml:"entropy"`      /* Fraction of random bytes per file, the rest is zeros. Defaults to 1 */
+	Seed         int64    `yaml:"seed"`
+
+	SaveRoot   string `yaml:"save_root"`   /* Variable to save the root path to */
+	SavePaths  string `yaml:"save_paths"`  /* Array to append the path of each file to */
+	SaveHashes string `yaml:"save_hashes"` /* Array to append the sha256 of each file to */
+}
+
+type generatedFile struct {
+	path string /* Relative to the root */
+	size int64
+	seed int64
+}
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// parseSize reads sizes like 500B, 10KB or 1.5MB, units are powers of 1024
+func parseSize(size string) (int64, error) {
+	size = strings.ToUpper(strings.TrimSpace(size))
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(size, unit.suffix) {
+			multiplier = unit.bytes
+			size = strings.TrimSpace(strings.TrimSuffix(size, unit.suffix))
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(size, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+func (gen Generate) root() string {
+	if gen.Root == "" {
+		return defaultGenerateRoot
+	}
+	return gen.Root
+}
+
+func (gen Generate) entropy() float64 {
+	if gen.Entropy == nil {
+		return 1
+	}
+	return *gen.Entropy
+}
+
+func (gen Generate) sizes() (int64, int64, error) {
+	min, err := parseSize(gen.MinSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	max := min
+	if gen.MaxSize != "" {
+		if max, err = parseSize(gen.MaxSize); err != nil {
+			return 0, 0, err
+		}
+	}
+	if max < min {
+		return 0, 0, errors.New("max_size is smaller than min_size")
+	}
+	return min, max, nil
+}
+
+func (gen Generate) validate() error {
+	if gen.Files <= 0 {
+		return errors.New("files must be positive")
+	}
+	if _, _, err := gen.sizes(); err != nil {
+		return err
+	}
+	if gen.Depth < 0 {
+		return errors.New("depth must not be negative")
+	}
+	if e := gen.entropy(); e < 0 || e > 1 {
+		return errors.New("entropy must be between 0 and 1")
+	}
+	switch gen.Distribution {
+	case "", uniform, logUniform:
+	default:
+		return errors.New("distribution must be UNIFORM or LOG_UNIFORM")
+	}
+	/* The root is removed before the files are written */
+	if gen.Root != "" {
+		if !path.IsAbs(gen.Root) || path.Clean(gen.Root) != gen.Root || gen.Root == "/" {
+			return errors.New("root must be a clean absolute path other than /")
+		}
+		if strings.ContainsAny(gen.Root, "'\"\n") {
+			return errors.New("root must not contain quotes or newlines")
+		}
+	}
+	return nil
+}
+
+// shellQuote quotes s as a single word for sh
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// plan decides the path, size and content seed of every file.  Files are
+// spread over a chain of nested directories dir_1/dir_2/..., file j
+// sitting j % depth levels below the root.
+func (gen Generate) plan() ([]generatedFile, error) {
+	min, max, err := gen.sizes()
+	if err != nil {
+		return nil, err
+	}
+	depth := gen.Depth
+	if depth < 1 {
+		depth = 1
+	}
+	rng := rand.New(rand.NewSource(gen.Seed))
+	files := make([]generatedFile, gen.Files)
+	for j := range files {
+		var size int64
+		switch {
+		case max == min:
+			size = min
+		case gen.Distribution == logUniform:
+			low := math.Log(float64(min) + 1)
+			high := math.Log(float64(max) + 1)
+			size = int64(math.Exp(low+rng.Float64()*(high-low))) - 1
+		default:
+			size = min + rng.Int63n(max-min+1)
+		}
+		dirs := make([]string, 0, depth)
+		for level := 1; level <= j%depth; level++ {
+			dirs = append(dirs, "dir_"+strconv.Itoa(level))
+		}
+		files[j] = generatedFile{
+			path: path.Join(append(dirs, fmt.Sprintf("file_%d_size_%d", j, size))...),
+			size: size,
+			seed: rng.Int63(),
+		}
+	}
+	return files, nil
+}
+
+// writeTar streams the planned files as a tar archive and returns the
+// sha256 of each file's content
+func (gen Generate) writeTar(w io.Writer, files []generatedFile) ([]string, error) {
+	tw := tar.NewWriter(w)
+	hashes := make([]string, 0, len(files))
+	madeDirs := make(map[string]bool)
+	for _, file := range files {
+		/* Directories first so that tar creates them with sane modes */
+		dir := path.Dir(file.path)
+		for dir != "." && !madeDirs[dir] {
+			if err := tw.WriteHeader(&tar.Header{Name: dir + "/", Mode: 0755, Typeflag: tar.TypeDir}); err != nil {
+				return nil, err
+			}
+			madeDirs[dir] = true
+			dir = path.Dir(dir)
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: file.path, Mode: 0644, Size: file.size}); err != nil {
+			return nil, err
+		}
+		hash := sha256.New()
+		randomBytes := int64(math.Round(gen.entropy() * float64(file.size)))
+		content := io.MultiReader(
+			io.LimitReader(rand.New(rand.NewSource(file.seed)), randomBytes),
+			io.LimitReader(zeroReader{}, file.size-randomBytes))
+		if _, err := io.Copy(io.MultiWriter(tw, hash), content); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hex.EncodeToString(hash.Sum(nil)))
+	}
+	return hashes, tw.Close()
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func handleGenerate(pods GetPodsOutput, step *Step, summary *Summary, env []string, envArrays map[string][]string, nodeIndices []int) ([]string, map[string][]string) {
+	gen := step.Generate
+	root := gen.root()
+	color.Cyan("### Generating %d files in %s on nodes %v", gen.Files, root, nodeIndices)
+	files, err := gen.plan()
+	if err != nil {
+		color.Red("Failed to plan generated files: %s", err)
+		summary.Failures++
+		return env, envArrays
+	}
+
+	type generateResult struct {
+		hashes   []string
+		err      error
+		timedOut bool
+	}
+	results := make(chan generateResult)
+	for _, idx := range nodeIndices {
+		go func(podName string) {
+			reader, writer := io.Pipe()
+			hashes := make(chan []string, 1)
+			go func() {
+				h, err := gen.writeTar(writer, files)
+				hashes <- h
+				writer.CloseWithError(err)
+			}()
+			quoted := shellQuote(root)
+			command := "rm -rf " + quoted + " && mkdir -p " + quoted + " && tar -x -C " + quoted
+			timedOut, err := runInPodWithInput(podName, command, reader, step.Timeout)
+			reader.Close()
+			results <- generateResult{<-hashes, err, timedOut}
+		}(pods.Items[idx-1].Metadata.Name)
+	}
+
+	var hashes []string
+	for range nodeIndices {
+		result := <-results
+		switch {
+		case result.timedOut:
+			summary.Timeouts++
+		case result.err != nil:
+			color.Red("Failed to generate files: %s", result.err)
+			summary.Failures++
+		default:
+			hashes = result.hashes
+		}
+	}
+	if hashes == nil {
+		return env, envArrays
+	}
+
+	if gen.SaveRoot != "" {
+		color.Magenta("### Saving generated root to variable %s: %s", gen.SaveRoot, root)
+		env = append(env, gen.SaveRoot+"=\""+root+"\"")
+	}
+	if gen.SavePaths != "" {
+		color.Magenta("### Appending %d generated paths to array variable %s", len(files), gen.SavePaths)
+		for _, file := range files {
+			envArrays[gen.SavePaths] = append(envArrays[gen.SavePaths], path.Join(root, file.path))
+		}
+	}
+	if gen.SaveHashes != "" {
+		color.Magenta("### Appending %d sha256 hashes to array variable %s", len(hashes), gen.SaveHashes)
+		envArrays[gen.SaveHashes] = append(envArrays[gen.SaveHashes], hashes...)
+	}
+	return env, envArrays
+}
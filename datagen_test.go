@@ -0,0 +1,162 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// test that sizes with units are parsed
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{
+		"500B":  500,
+		"500":   500,
+		"10KB":  10 * 1024,
+		"1.5mb": 3 * 512 * 1024,
+		"1 GB":  1 << 30,
+		"1TB":   1 << 40,
+	}
+	for input, want := range cases {
+		got, err := parseSize(input)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %s", input, err)
+		}
+		if got != want {
+			t.Errorf("%q parsed as %d, expected %d", input, got, want)
+		}
+	}
+	for _, input := range []string{"", "KB", "-1KB", "10XB"} {
+		if _, err := parseSize(input); err == nil {
+			t.Errorf("Expected %q to fail to parse", input)
+		}
+	}
+}
+
+// test that the generated tree respects the requested shape
+func TestGeneratePlan(t *testing.T) {
+	gen := Generate{Files: 50, MinSize: "1KB", MaxSize: "4KB", Depth: 3, Seed: 7}
+	files, err := gen.plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := gen.plan()
+	for j, file := range files {
+		if file.size < 1024 || file.size > 4096 {
+			t.Errorf("File %s has size %d outside of 1KB-4KB", file.path, file.size)
+		}
+		if levels := strings.Count(file.path, "/"); levels != j%3 {
+			t.Errorf("File %s nested %d levels deep, expected %d", file.path, levels, j%3)
+		}
+		if file != again[j] {
+			t.Fatalf("Plan is not deterministic: %v != %v", file, again[j])
+		}
+	}
+
+	gen.Distribution = logUniform
+	gen.MaxSize = "1MB"
+	files, err = gen.plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if file.size < 1024 || file.size > 1<<20 {
+			t.Errorf("File %s has size %d outside of 1KB-1MB", file.path, file.size)
+		}
+	}
+}
+
+// test that the tar stream holds the planned content and matching hashes
+func TestGenerateTar(t *testing.T) {
+	entropy := 0.25
+	gen := Generate{Files: 5, MinSize: "100B", MaxSize: "2KB", Depth: 2, Entropy: &entropy, Seed: 3}
+	files, err := gen.plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	hashes, err := gen.writeTar(&archive, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var again bytes.Buffer
+	gen.writeTar(&again, files)
+	if !bytes.Equal(archive.Bytes(), again.Bytes()) {
+		t.Fatal("Generated archive is not deterministic")
+	}
+
+	tr := tar.NewReader(&archive)
+	j := 0
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+		content, _ := ioutil.ReadAll(tr)
+		if header.Name != files[j].path || int64(len(content)) != files[j].size {
+			t.Fatalf("Unexpected file %s of %d bytes, expected %v", header.Name, len(content), files[j])
+		}
+		sum := sha256.Sum256(content)
+		if hex.EncodeToString(sum[:]) != hashes[j] {
+			t.Errorf("Hash of %s does not match", header.Name)
+		}
+		random := len(content) / 4
+		if !bytes.Equal(content[random+1:], make([]byte, len(content)-random-1)) {
+			t.Errorf("File %s should be zeros after its random quarter", header.Name)
+		}
+		j++
+	}
+	if j != len(files) {
+		t.Fatalf("Expected %d files in archive, found %d", len(files), j)
+	}
+}
+
+// test that bad generate steps fail validation
+func TestGenerateValidation(t *testing.T) {
+	tooRandom := 1.5
+	for _, gen := range []Generate{
+		{Files: 0, MinSize: "1KB"},
+		{Files: 1, MinSize: "2KB", MaxSize: "1KB"},
+		{Files: 1, MinSize: "lots"},
+		{Files: 1, MinSize: "1KB", Entropy: &tooRandom},
+		{Files: 1, MinSize: "1KB", Distribution: "NORMAL"},
+		{Files: 1, MinSize: "1KB", Root: "/"},
+		{Files: 1, MinSize: "1KB", Root: "tmp/data"},
+		{Files: 1, MinSize: "1KB", Root: "/tmp/../var"},
+		{Files: 1, MinSize: "1KB", Root: "/tmp/data/"},
+		{Files: 1, MinSize: "1KB", Root: "/tmp/it's"},
+		{Files: 1, MinSize: "1KB", Root: "/tmp/a\nb"},
+	} {
+		if err := gen.validate(); err == nil {
+			t.Errorf("Expected %+v to fail validation", gen)
+		}
+	}
+	for _, root := range []string{"", "/tmp/kipfs data", "/data/$HOME"} {
+		gen := Generate{Files: 1, MinSize: "1KB", Root: root}
+		if err := gen.validate(); err != nil {
+			t.Errorf("Expected root %q to be valid: %s", root, err)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	for input, expected := range map[string]string{
+		"/tmp/kipfs-data": "'/tmp/kipfs-data'",
+		"/tmp/$HOME dir":  "'/tmp/$HOME dir'",
+		"it's":            `'it'\''s'`,
+	} {
+		if quoted := shellQuote(input); quoted != expected {
+			t.Errorf("shellQuote(%q) = %s, expected %s", input, quoted, expected)
+		}
+	}
+}
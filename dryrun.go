@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+var nodeRefRegex = regexp.MustCompile(`\[%s\]`)
+var iterRefRegex = regexp.MustCompile(`\[%i\]`)
+
+// expandCommand replaces [%s] in a step's command with the index of the
+// node it runs on and [%i] with the iteration
+func expandCommand(cmd string, node int, iter int) string {
+	command := nodeRefRegex.ReplaceAllString(cmd, "["+strconv.Itoa(node-1)+"]")
+	return iterRefRegex.ReplaceAllString(command, "["+strconv.Itoa(iter)+"]")
+}
+
+// resolveSeed picks the random seed of a test: the --seed flag if given,
+// then the seed in the test config, then one based on the time
+func resolveSeed(configSeed int64, override int64) int64 {
+	switch {
+	case override != 0:
+		return override
+	case configSeed != 0:
+		return configSeed
+	}
+	return time.Now().UnixNano()
+}
+
+// selectionRand picks random nodes for partitions and selections
+var selectionRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// seededPartition seeds the random numbers used to pick nodes and
+// partitions the nodes, so that runs of a test with the same seed select
+// the same nodes
+func seededPartition(config Config) (map[int][]int, error) {
+	selectionRand.Seed(config.Seed)
+	return partition(config)
+}
+
+// printPlan prints the nodes and command of every step iteration of a
+// test without running anything.  Node selections use the same random
+// numbers as a real run with the same seed.
+func printPlan(test Test, subsetPartition map[int][]int) {
+	color.Cyan("## Plan for test '%s': %d nodes, %d repetitions, seed %d",
+		test.Name, test.Config.Nodes, test.Config.Times, test.Config.Seed)
+	for subset := 1; subset <= len(subsetPartition); subset++ {
+		fmt.Printf("## Subset %d: nodes %v\n", subset, subsetPartition[subset])
+	}
+	if readiness := test.Config.Readiness; readiness != nil {
+		fmt.Printf("## Every repetition waits up to %s for all nodes to pass: %s\n", readiness.timeout(), readiness)
+	}
+	if sampling := test.Config.Sampling; sampling != nil {
+		fmt.Printf("## Command steps sample the resources of their nodes every %s\n", sampling.interval())
+	}
+	repetitionPartition := copyPartition(subsetPartition)
+	for i := 0; i < test.Config.Times; i++ {
+		color.Cyan("## Repetition %d", i+1)
+		if spec := test.Config.SubsetPartition; i > 0 && spec != nil && spec.EachRepetition {
+			if err := repartition(repetitionPartition, test.Config, spec); err != nil {
+				color.Red("## %s", err)
+			}
+			fmt.Printf("## Partition: %s\n", PartitionRecord{Subsets: repetitionPartition})
+		}
+		arrays := make(map[string][]string)
+		planSteps("setup", test.Setup, test.Config, copyPartition(repetitionPartition), arrays)
+		planSteps("steps", test.Steps, test.Config, copyPartition(repetitionPartition), arrays)
+		planSteps("teardown", test.Teardown, test.Config, copyPartition(repetitionPartition), arrays)
+	}
+	planSteps("finally", test.Finally, test.Config, copyPartition(subsetPartition), make(map[string][]string))
+}
+
+// planSteps prints the plan of a list of steps.  Arrays filled by
+// commands hold placeholders, as many as there would be if every command
+// printed all the lines it is expected to.
+func planSteps(name string, steps []Step, config Config, subsetPartition map[int][]int, arrays map[string][]string) {
+	if len(steps) == 0 {
+		return
+	}
+	color.Cyan("## %s", name)
+	for _, step := range steps {
+		switch {
+		case step.Repartition != nil:
+			if err := repartition(subsetPartition, config, step.partitionSpec(config)); err != nil {
+				color.Red("### Step '%s' fails to repartition nodes: %s", step.Name, err)
+				continue
+			}
+			color.Cyan("### Step '%s' repartitions nodes: %s", step.Name, PartitionRecord{Subsets: subsetPartition})
+		case step.PartitionNetwork != nil:
+			color.Cyan("### Step '%s' partitions the network into islands %v", step.Name, step.PartitionNetwork.islands(subsetPartition))
+		case step.HealNetwork:
+			color.Cyan("### Step '%s' heals the network", step.Name)
+		case step.Network != nil && step.Network.Between != nil:
+			color.Cyan("### Step '%s' impairs the network: %s", step.Name, step.Network)
+		case step.Prometheus != nil:
+			color.Cyan("### Step '%s' asserts on Prometheus: %s", step.Name, step.Prometheus)
+		}
+		if !step.selectsNodes() {
+			continue
+		}
+		iterations := 1
+		if step.For != nil {
+			if step.For.IterStructure == "BOUND" {
+				iterations = step.For.Number
+			} else {
+				iterations = len(arrays[step.For.IterStructure])
+			}
+		}
+		if iterations == 0 {
+			color.Yellow("### Step '%s' has no iterations", step.Name)
+		}
+		if step.SaveSelection != "" {
+			delete(arrays, step.SaveSelection)
+		}
+		for iter := 0; iter < iterations; iter++ {
+			nodeIndices := selectNodes(step, config, subsetPartition, nil, arrays)
+			if step.SaveSelection != "" {
+				saveSelection(arrays, step.SaveSelection, nodeIndices)
+			}
+			color.Cyan("### Step '%s' iteration %d on nodes %v", step.Name, iter, nodeIndices)
+			if step.Chaos != nil && step.Chaos.Schedule != nil {
+				color.Yellow("    repeated on new selections at random intervals, the first nodes are shown")
+			}
+			if step.Selection != nil && step.Selection.filtersPods() {
+				color.Yellow("    nodes whose pods don't match labels, annotations or kube_node are left out at run time")
+			}
+			if step.When != "" {
+				color.Yellow("    only if %s", step.When)
+			}
+			if step.MaxDuration != "" || step.P95Below != "" {
+				color.Yellow("    asserts on durations: max_duration %s, p95_below %s", step.MaxDuration, step.P95Below)
+			}
+			for _, idx := range nodeIndices {
+				if step.Generate != nil {
+					fmt.Printf("    [%d] generate %d files in %s (seed %d)\n", idx, step.Generate.Files, step.Generate.root(), step.Generate.Seed)
+				} else if step.Network != nil {
+					fmt.Printf("    [%d] network %s\n", idx, step.Network)
+				} else if step.Chaos != nil {
+					fmt.Printf("    [%d] chaos %s\n", idx, step.Chaos)
+				} else {
+					fmt.Printf("    [%d] %s\n", idx, expandCommand(step.CMD, idx, iter))
+				}
+			}
+			for _, output := range step.Outputs {
+				if output.AppendTo != "" {
+					arrays[output.AppendTo] = append(arrays[output.AppendTo], make([]string, len(nodeIndices))...)
+				}
+			}
+			if step.Generate != nil && len(nodeIndices) != 0 {
+				for _, array := range []string{step.Generate.SavePaths, step.Generate.SaveHashes} {
+					if array != "" {
+						arrays[array] = append(arrays[array], make([]string, step.Generate.Files)...)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandCommand(t *testing.T) {
+	command := expandCommand("ipfs pin add ${HASH[%i]} && echo ${PEER[%s]}", 3, 7)
+	if command != "ipfs pin add ${HASH[7]} && echo ${PEER[2]}" {
+		t.Fatalf("Unexpected command: %s", command)
+	}
+}
+
+// test that the same seed partitions and selects the same nodes
+func TestSeededSelection(t *testing.T) {
+	test, err := loadTest("test_tests/selection_framework/succeedtests/rand_even_subset.yml", newTestConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := Step{Selection: &Selection{Range: &Range{Order: random, Number: 3}}}
+
+	test.Config.Seed = resolveSeed(test.Config.Seed, 42)
+	first, err := seededPartition(test.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstNodes := selectNodes(step, test.Config, first, nil, nil)
+
+	second, _ := seededPartition(test.Config)
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("Partitions differ with the same seed: %v %v", first, second)
+	}
+	if nodes := selectNodes(step, test.Config, second, nil, nil); !reflect.DeepEqual(nodes, firstNodes) {
+		t.Fatalf("Selections differ with the same seed: %v %v", firstNodes, nodes)
+	}
+
+	if resolveSeed(5, 0) != 5 || resolveSeed(5, 9) != 9 || resolveSeed(0, 0) == 0 {
+		t.Fatal("Unexpected seed resolution")
+	}
+}
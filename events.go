@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Event is something a step did to the cluster besides running
+// commands, such as cutting or slowing down the network
+type Event struct {
+	Time       time.Time `json:"time"`
+	Repetition int       `json:"repetition"` /* 0 for the finally phase */
+	Phase      string    `json:"phase"`
+	Step       string    `json:"step"`
+	Kind       string    `json:"kind"`
+	Nodes      []int     `json:"nodes,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+}
+
+// repetitionOf is the repetition the summary is at, or 0 in the finally
+// phase, which runs once after all of them
+func repetitionOf(summary *Summary, phase string) int {
+	if phase == "finally" {
+		return 0
+	}
+	return summary.TestsRan + 1
+}
+
+// whenLabel describes the repetition and phase something happened in
+func whenLabel(repetition int, phase string) string {
+	if phase == "finally" {
+		return "finally"
+	}
+	return fmt.Sprintf("repetition %d, %s", repetition, phase)
+}
+
+// addEvent records an event of the repetition the summary is at
+func (summary *Summary) addEvent(phase string, step string, kind string, nodes []int, detail string) {
+	event := Event{
+		Time:       time.Now(),
+		Repetition: repetitionOf(summary, phase),
+		Phase:      phase,
+		Step:       step,
+		Kind:       kind,
+		Nodes:      nodes,
+		Detail:     detail,
+	}
+	summary.Events = append(summary.Events, event)
+}
+
+func printEvents(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	fmt.Println("== Events:")
+	for _, event := range events {
+		line := fmt.Sprintf("==   %s, step '%s': %s", whenLabel(event.Repetition, event.Phase), event.Step, event.Kind)
+		if len(event.Nodes) != 0 {
+			line += fmt.Sprintf(" on nodes %v", event.Nodes)
+		}
+		if event.Detail != "" {
+			line += ", " + event.Detail
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// exprScope resolves the names an expression refers to
+type exprScope interface {
+	variable(name string) (string, error)
+	element(name string, index int) (string, error)
+	length(name string) (int, error)
+	iteration() int
+}
+
+// exprNode is a parsed expression.  Expressions are small: literals,
+// variables, array elements (NAME[%i] or NAME[<expr>]), len(NAME), the
+// arithmetic operators + - * / %, comparisons and && || !.  All values
+// are strings; operators that need numbers parse them and fail if they
+// can't.  Like bash, / on two integers truncates.
+type exprNode struct {
+	op    string /* lit, var, iter, elem, len, neg, ! or a binary operator */
+	value string /* Literal value or variable name */
+	index *exprNode
+	left  *exprNode
+	right *exprNode
+}
+
+type exprToken struct {
+	kind  string /* num, str, ident, iter, op, eof */
+	value string
+	pos   int
+}
+
+/* Binary operators by precedence, loosest first */
+var exprPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func parseExpr(input string) (*exprNode, error) {
+	tokens, err := lexExpr(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens, input: input}
+	node, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != "eof" {
+		return nil, p.errorf(tok, "unexpected %q", tok.value)
+	}
+	return node, nil
+}
+
+func lexExpr(input string) ([]exprToken, error) {
+	tokens := make([]exprToken, 0)
+	depth := 0 /* Bracket depth, %i is only an index inside brackets */
+	for i := 0; i < len(input); {
+		c := rune(input[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(input) && (unicode.IsDigit(rune(input[i])) || input[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, exprToken{"num", input[start:i], start})
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(input) && (input[i] == '_' || input[i] == '.' ||
+				unicode.IsLetter(rune(input[i])) || unicode.IsDigit(rune(input[i]))) {
+				i++
+			}
+			tokens = append(tokens, exprToken{"ident", input[start:i], start})
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(input[i+1:], input[i])
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at column %d in %q", i+1, input)
+			}
+			tokens = append(tokens, exprToken{"str", input[i+1 : i+1+end], i})
+			i += end + 2
+		case c == '%' && depth > 0 && i+1 < len(input) && (input[i+1] == 'i' || input[i+1] == 's'):
+			if input[i+1] == 's' {
+				return nil, fmt.Errorf("node index %%s is not available in expressions (column %d in %q)", i+1, input)
+			}
+			tokens = append(tokens, exprToken{"iter", "%i", i})
+			i += 2
+		default:
+			op := ""
+			for _, candidate := range []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "+", "-", "*", "/", "%", "!", "(", ")", "[", "]"} {
+				if strings.HasPrefix(input[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at column %d in %q", c, i+1, input)
+			}
+			if op == "[" {
+				depth++
+			} else if op == "]" {
+				depth--
+			}
+			tokens = append(tokens, exprToken{"op", op, i})
+			i += len(op)
+		}
+	}
+	return append(tokens, exprToken{"eof", "", len(input)}), nil
+}
+
+type exprParser struct {
+	tokens []exprToken
+	input  string
+	pos    int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != "eof" {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *exprParser) errorf(tok exprToken, format string, args ...interface{}) error {
+	return fmt.Errorf("%s at column %d in %q", fmt.Sprintf(format, args...), tok.pos+1, p.input)
+}
+
+func (p *exprParser) expect(op string) error {
+	tok := p.next()
+	if tok.kind != "op" || tok.value != op {
+		return p.errorf(tok, "expected %q", op)
+	}
+	return nil
+}
+
+func (p *exprParser) parseBinary(level int) (*exprNode, error) {
+	if level == len(exprPrecedence) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.kind != "op" || !containsString(exprPrecedence[level], tok.value) {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &exprNode{op: tok.value, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseUnary() (*exprNode, error) {
+	tok := p.peek()
+	if tok.kind == "op" && (tok.value == "!" || tok.value == "-") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		op := tok.value
+		if op == "-" {
+			op = "neg"
+		}
+		return &exprNode{op: op, left: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (*exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case "num":
+		if _, err := strconv.ParseFloat(tok.value, 64); err != nil {
+			return nil, p.errorf(tok, "invalid number %q", tok.value)
+		}
+		return &exprNode{op: "lit", value: tok.value}, nil
+	case "str":
+		return &exprNode{op: "lit", value: tok.value}, nil
+	case "iter":
+		return &exprNode{op: "iter"}, nil
+	case "ident":
+		switch {
+		case tok.value == "true" || tok.value == "false":
+			return &exprNode{op: "lit", value: tok.value}, nil
+		case tok.value == "len" && p.peek().value == "(":
+			p.next()
+			name := p.next()
+			if name.kind != "ident" {
+				return nil, p.errorf(name, "len() takes an array name")
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return &exprNode{op: "len", value: name.value}, nil
+		case p.peek().value == "[":
+			p.next()
+			index, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			return &exprNode{op: "elem", value: tok.value, index: index}, nil
+		}
+		return &exprNode{op: "var", value: tok.value}, nil
+	case "op":
+		if tok.value == "(" {
+			node, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return node, nil
+		}
+	case "eof":
+		return nil, p.errorf(tok, "unexpected end of expression")
+	}
+	return nil, p.errorf(tok, "unexpected %q", tok.value)
+}
+
+// variables returns the names of all variables and arrays the
+// expression refers to
+func (node *exprNode) variables() []string {
+	if node == nil {
+		return nil
+	}
+	names := make([]string, 0)
+	switch node.op {
+	case "var", "elem", "len":
+		names = append(names, node.value)
+	}
+	for _, child := range []*exprNode{node.index, node.left, node.right} {
+		names = append(names, child.variables()...)
+	}
+	return names
+}
+
+func (node *exprNode) eval(scope exprScope) (string, error) {
+	switch node.op {
+	case "lit":
+		return node.value, nil
+	case "var":
+		return scope.variable(node.value)
+	case "len":
+		n, err := scope.length(node.value)
+		return strconv.Itoa(n), err
+	case "iter":
+		return strconv.Itoa(scope.iteration()), nil
+	case "elem":
+		value, err := node.index.eval(scope)
+		if err != nil {
+			return "", err
+		}
+		index, err := exprNumber(value)
+		if err != nil {
+			return "", err
+		}
+		return scope.element(node.value, int(index))
+	case "!":
+		value, err := node.left.eval(scope)
+		return exprBool(!exprTruthy(value)), err
+	case "neg":
+		value, err := node.left.eval(scope)
+		if err != nil {
+			return "", err
+		}
+		f, err := exprNumber(value)
+		return formatNumber(-f), err
+	case "&&", "||":
+		left, err := node.left.eval(scope)
+		if err != nil {
+			return "", err
+		}
+		if exprTruthy(left) == (node.op == "||") {
+			return exprBool(node.op == "||"), nil
+		}
+		right, err := node.right.eval(scope)
+		return exprBool(exprTruthy(right)), err
+	}
+
+	left, err := node.left.eval(scope)
+	if err != nil {
+		return "", err
+	}
+	right, err := node.right.eval(scope)
+	if err != nil {
+		return "", err
+	}
+	l, lErr := strconv.ParseFloat(strings.TrimSpace(left), 64)
+	r, rErr := strconv.ParseFloat(strings.TrimSpace(right), 64)
+	numeric := lErr == nil && rErr == nil
+	switch node.op {
+	case "==", "!=":
+		equal := left == right
+		if numeric {
+			equal = l == r
+		}
+		return exprBool(equal == (node.op == "==")), nil
+	case "<", "<=", ">", ">=":
+		cmp := strings.Compare(left, right)
+		if numeric {
+			cmp = compareFloats(l, r)
+		}
+		switch node.op {
+		case "<":
+			return exprBool(cmp < 0), nil
+		case "<=":
+			return exprBool(cmp <= 0), nil
+		case ">":
+			return exprBool(cmp > 0), nil
+		default:
+			return exprBool(cmp >= 0), nil
+		}
+	case "+":
+		if !numeric {
+			return left + right, nil
+		}
+		return formatNumber(l + r), nil
+	}
+
+	if !numeric {
+		return "", fmt.Errorf("operator %s needs numbers, got %q and %q", node.op, left, right)
+	}
+	integers := isInteger(left) && isInteger(right)
+	switch node.op {
+	case "-":
+		return formatNumber(l - r), nil
+	case "*":
+		return formatNumber(l * r), nil
+	case "/", "%":
+		if r == 0 {
+			return "", fmt.Errorf("division by zero")
+		}
+		if node.op == "%" {
+			return formatNumber(math.Mod(l, r)), nil
+		}
+		if integers {
+			return formatNumber(math.Trunc(l / r)), nil
+		}
+		return formatNumber(l / r), nil
+	}
+	return "", fmt.Errorf("unknown operator %s", node.op)
+}
+
+func isInteger(value string) bool {
+	_, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	return err == nil
+}
+
+func exprNumber(value string) (float64, error) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", value)
+	}
+	return f, nil
+}
+
+// exprTruthy is false for "", "0" and "false", true for everything else
+func exprTruthy(value string) bool {
+	switch strings.TrimSpace(value) {
+	case "", "0", "false":
+		return false
+	}
+	return true
+}
+
+func exprBool(b bool) string {
+	return strconv.FormatBool(b)
+}
+
+func formatNumber(f float64) string {
+	if f == math.Trunc(f) && math.Abs(f) < 1e15 {
+		return strconv.FormatInt(int64(f), 10)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func compareFloats(l, r float64) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+)
+
+type testScope struct {
+	vars   map[string]string
+	arrays map[string][]string
+	iter   int
+}
+
+func (scope testScope) variable(name string) (string, error) {
+	return scope.vars[name], nil
+}
+
+func (scope testScope) element(name string, index int) (string, error) {
+	if index < 0 || index >= len(scope.arrays[name]) {
+		return "", nil
+	}
+	return scope.arrays[name][index], nil
+}
+
+func (scope testScope) length(name string) (int, error) {
+	return len(scope.arrays[name]), nil
+}
+
+func (scope testScope) iteration() int {
+	return scope.iter
+}
+
+// test that expressions evaluate to the expected values
+func TestExprEval(t *testing.T) {
+	scope := testScope{
+		vars:   map[string]string{"N": "5", "Y": "3", "NAME": "peer"},
+		arrays: map[string][]string{"SUCCESS": {"yes", "no", "yes"}},
+		iter:   1,
+	}
+	cases := map[string]string{
+		"2*Y*N + 3*Y":                        "39",
+		"N / 2 + 1":                          "3",
+		"N % 2":                              "1",
+		"-N + 1":                             "-4",
+		"(N + 1) * 2":                        "12",
+		"N / 2.0":                            "2.5",
+		"SUCCESS[%i] == 'no'":                "true",
+		"SUCCESS[%i + 1] == \"yes\"":         "true",
+		"SUCCESS[0] != SUCCESS[1]":           "true",
+		"len(SUCCESS) >= 3 && N > 4":         "true",
+		"!(N > 4) || NAME == 'other'":        "false",
+		"NAME + '-' + Y":                     "peer-3",
+		"UNSET == ''":                        "true",
+		"10 > 9":                             "true",
+		"'abc' < 'abd'":                      "true",
+		"SUCCESS[7] == '' && len(NONE) == 0": "true",
+	}
+	for input, want := range cases {
+		node, err := parseExpr(input)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %s", input, err)
+		}
+		got, err := node.eval(scope)
+		if err != nil {
+			t.Fatalf("Failed to evaluate %q: %s", input, err)
+		}
+		if got != want {
+			t.Errorf("%q evaluated to %q, expected %q", input, got, want)
+		}
+	}
+}
+
+// test that malformed expressions are rejected when parsed
+func TestExprParseErrors(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"N +",
+		"(N + 1",
+		"HASH[%s] == 'x'",
+		"'unterminated",
+		"N = 1",
+		"len(1)",
+	} {
+		if _, err := parseExpr(input); err == nil {
+			t.Errorf("Expected %q to fail to parse", input)
+		}
+	}
+}
+
+// test that arithmetic on non-numbers fails at evaluation
+func TestExprEvalErrors(t *testing.T) {
+	scope := testScope{vars: map[string]string{"NAME": "peer"}}
+	for _, input := range []string{"NAME * 2", "1 / 0", "-NAME"} {
+		node, err := parseExpr(input)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %s", input, err)
+		}
+		if _, err := node.eval(scope); err == nil {
+			t.Errorf("Expected %q to fail to evaluate", input)
+		}
+	}
+}
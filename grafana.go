@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const (
+	defaultGrafanaDashboard = "dashboard/db/kubernetes-pod-resources"
+	defaultGrafanaNamespace = "monitoring"
+	defaultGrafanaService   = "grafana"
+)
+
+// Grafana sets where the metrics of a test are shown and whether the
+// test annotates them.  Without a URL, Grafana is found through the node
+// port of its service.
+type Grafana struct {
+	URL       string `yaml:"url"`       /* Base URL, e.g. http://localhost:3000 */
+	Dashboard string `yaml:"dashboard"` /* Path of the dashboard under the base URL */
+	Namespace string `yaml:"namespace"` /* Namespace of the service, default monitoring */
+	Service   string `yaml:"service"`   /* Name of the service, default grafana */
+	Annotate  bool   `yaml:"annotate"`  /* Post annotations for the test and its steps */
+}
+
+/* Used for tests whose config has no grafana entry */
+var defaultGrafana = Grafana{}
+
+func (grafana *Grafana) dashboard() string {
+	if grafana.Dashboard == "" {
+		return defaultGrafanaDashboard
+	}
+	return strings.Trim(grafana.Dashboard, "/")
+}
+
+func (grafana *Grafana) namespace() string {
+	if grafana.Namespace == "" {
+		return defaultGrafanaNamespace
+	}
+	return grafana.Namespace
+}
+
+func (grafana *Grafana) service() string {
+	if grafana.Service == "" {
+		return defaultGrafanaService
+	}
+	return grafana.Service
+}
+
+func grafanaConfig(config Config) *Grafana {
+	if config.Grafana == nil {
+		return &defaultGrafana
+	}
+	return config.Grafana
+}
+
+// base returns the base URL of Grafana, or an empty string if it can't
+// be found
+func (grafana *Grafana) base() string {
+	if grafana.URL != "" {
+		return strings.TrimSuffix(grafana.URL, "/")
+	}
+	// Get the grafana service dynamically; this will work even for real k8s deployments instead of just minikube
+	var portOut bytes.Buffer
+	portCmd := exec.Command("kubectl", "get", "service", grafana.service(), "--namespace="+grafana.namespace(), "-o", "jsonpath='{.spec.ports[0].nodePort}'")
+	portCmd.Stdout = &portOut
+	if portCmd.Run() != nil {
+		return ""
+	}
+	var addressOut bytes.Buffer
+	addressCmd := exec.Command("kubectl", "get", "nodes", "-o", "jsonpath='{.items[0].status.addresses[?(@.type == \"InternalIP\")].address}'")
+	addressCmd.Stdout = &addressOut
+	if addressCmd.Run() != nil {
+		return ""
+	}
+	address := strings.Replace(addressOut.String(), "'", "", -1)
+	port := strings.Replace(portOut.String(), "'", "", -1)
+	if address == "" || port == "" {
+		return ""
+	}
+	return fmt.Sprintf("http://%s:%s", address, port)
+}
+
+// metricsLink returns the link to the dashboard over the time of the test
+func metricsLink(base string, dashboard string, start time.Time, end time.Time) string {
+	return base + "/" + dashboard + "?from=" + unixToStr(start.Unix()) + "&to=" + unixToStr(end.Unix())
+}
+
+// grafanaAnnotation is an annotation as posted to /api/annotations
+type grafanaAnnotation struct {
+	Time    int64    `json:"time"` /* Milliseconds */
+	TimeEnd int64    `json:"timeEnd,omitempty"`
+	Tags    []string `json:"tags"`
+	Text    string   `json:"text"`
+}
+
+// grafanaAnnotator posts annotations to Grafana until one fails
+type grafanaAnnotator struct {
+	base   string
+	test   string
+	token  string /* API token from GRAFANA_TOKEN */
+	client http.Client
+}
+
+// annotator annotates the running test, nil if it doesn't annotate
+var annotator *grafanaAnnotator
+
+// startAnnotations annotates the start of a test if its config asks for it
+func startAnnotations(test Test) {
+	annotator = nil
+	grafana := grafanaConfig(test.Config)
+	if !grafana.Annotate {
+		return
+	}
+	base := grafana.base()
+	if base == "" {
+		color.Yellow("## Grafana not found, the test will not be annotated")
+		return
+	}
+	annotator = &grafanaAnnotator{
+		base:   base,
+		test:   test.Name,
+		token:  os.Getenv("GRAFANA_TOKEN"),
+		client: http.Client{Timeout: 10 * time.Second},
+	}
+	annotator.post(time.Now(), time.Time{}, "Test '"+test.Name+"' started", "start")
+}
+
+// annotateStep annotates the time a step ran, if the test is annotated
+func annotateStep(phase string, step string, repetition int, start time.Time) {
+	if annotator == nil {
+		return
+	}
+	text := fmt.Sprintf("Step '%s' (%s)", step, whenLabel(repetition, phase))
+	annotator.post(start, time.Now(), text, "step", phase)
+}
+
+// endAnnotations annotates the end of the test
+func endAnnotations(summary Summary) {
+	if annotator == nil {
+		return
+	}
+	annotator.post(summary.End, time.Time{}, fmt.Sprintf("Test '%s' ended: %d/%d (success/failure), %d timeouts",
+		annotator.test, summary.Successes, summary.Failures, summary.Timeouts), "end")
+	annotator = nil
+}
+
+func (a *grafanaAnnotator) post(start time.Time, end time.Time, text string, tags ...string) {
+	annotation := grafanaAnnotation{
+		Time: start.UnixNano() / int64(time.Millisecond),
+		Tags: append([]string{"kubernetes-ipfs", a.test}, tags...),
+		Text: text,
+	}
+	if !end.IsZero() {
+		annotation.TimeEnd = end.UnixNano() / int64(time.Millisecond)
+	}
+	if err := a.send(annotation); err != nil {
+		color.Yellow("## Failed to annotate Grafana, giving up annotations: %s", err)
+		annotator = nil
+	}
+}
+
+func (a *grafanaAnnotator) send(annotation grafanaAnnotation) error {
+	body, err := json.Marshal(annotation)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", a.base+"/api/annotations", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMetricsLink(t *testing.T) {
+	grafana := grafanaConfig(Config{Grafana: &Grafana{URL: "http://localhost:3000/", Dashboard: "/d/abc/pods/"}})
+	start := time.Unix(1500000000, 0)
+	link := metricsLink(grafana.base(), grafana.dashboard(), start, start.Add(time.Minute))
+	if link != "http://localhost:3000/d/abc/pods?from=1500000000000&to=1500000060000" {
+		t.Fatalf("Unexpected link %s", link)
+	}
+	grafana = grafanaConfig(Config{})
+	if grafana.dashboard() != defaultGrafanaDashboard || grafana.namespace() != "monitoring" || grafana.service() != "grafana" {
+		t.Fatalf("Unexpected defaults %s, %s, %s", grafana.dashboard(), grafana.namespace(), grafana.service())
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	annotations := make([]grafanaAnnotation, 0)
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/annotations" || r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var annotation grafanaAnnotation
+		if err := json.NewDecoder(r.Body).Decode(&annotation); err != nil {
+			t.Error(err)
+		}
+		annotations = append(annotations, annotation)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	defer func() { annotator = nil }()
+
+	test := Test{Name: "pins", Config: Config{Grafana: &Grafana{URL: server.URL, Annotate: true}}}
+	t.Setenv("GRAFANA_TOKEN", "secret")
+	startAnnotations(test)
+	start := time.Now()
+	annotateStep("steps", "add", 2, start)
+	annotateStep("finally", "clean", 0, start)
+	endAnnotations(Summary{End: time.Now(), Successes: 3})
+	if len(annotations) != 4 {
+		t.Fatalf("Expected 4 annotations, got %+v", annotations)
+	}
+	if annotations[1].Text != "Step 'add' (repetition 2, steps)" || annotations[1].TimeEnd < annotations[1].Time ||
+		annotations[1].Time != start.UnixNano()/int64(time.Millisecond) {
+		t.Errorf("Unexpected step annotation %+v", annotations[1])
+	}
+	if annotations[2].Text != "Step 'clean' (finally)" || annotations[3].Text != "Test 'pins' ended: 3/0 (success/failure), 0 timeouts" {
+		t.Errorf("Unexpected annotations %+v", annotations)
+	}
+	if tags := annotations[0].Tags; len(tags) != 3 || tags[1] != "pins" || tags[2] != "start" {
+		t.Errorf("Unexpected tags %v", tags)
+	}
+
+	/* A failed annotation stops the others */
+	status = http.StatusUnauthorized
+	startAnnotations(test)
+	annotateStep("steps", "add", 1, time.Now())
+	if annotator != nil || len(annotations) != 5 {
+		t.Fatalf("Expected annotations to stop after a failure, got %d", len(annotations))
+	}
+}
@@ -28,7 +28,6 @@ echo "  N_times_5: ""$(( $1 * 5 ))" >> "tests/config.yml"
 echo "  N_minus_1: ""$(( $1 - 1 ))" >> "tests/config.yml"
 echo "  N_minus_2: ""$(( $1 - 2 ))" >> "tests/config.yml"
 echo "  N_minus_3: ""$(( $1 - 3 ))" >> "tests/config.yml"
-echo "  Block_successes: ""$(( ($2 * 3) + ($2 * $1 * 2) ))" >> "tests/config.yml"
 depart=$(( ($1 / 2) + 1 ))
 stay=$(( $1 - $depart ))
 add_rm=$(( 2 * ($1 * $1) + $depart + ($depart * $stay) ))
@@ -47,9 +47,4 @@ if [[ $3 ]]; then
     exit 0
 fi
 
-FILE_NAMES=$(find tests -not -name "config.yml" -name "*.yml" | sort)
-
-for file in $FILE_NAMES; do
-  echo $file
-  kubernetes-ipfs $file
-done
+kubernetes-ipfs suite tests
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// lintIssue is a problem found in a test file.  Line and Column are 0
+// when the problem can't be tied to a position.
+type lintIssue struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (issue lintIssue) String() string {
+	switch {
+	case issue.Line == 0:
+		return fmt.Sprintf("%s: %s", issue.File, issue.Message)
+	case issue.Column == 0:
+		return fmt.Sprintf("%s:%d: %s", issue.File, issue.Line, issue.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", issue.File, issue.Line, issue.Column, issue.Message)
+}
+
+/* Errors of the yaml package start with the line they refer to */
+var yamlLineRegex = regexp.MustCompile(`line ([0-9]+): (.*)`)
+var yamlFieldRegex = regexp.MustCompile(`field (\S+) not found`)
+
+/* Array references in commands, as expanded by handleStep */
+var arrayRefRegex = regexp.MustCompile(`([a-zA-Z_][a-zA-Z0-9_]*)\[(%s|%i)\]`)
+
+// runLint prints the issues found in the test files in paths and
+// returns 1 if there were any
+func runLint(paths []string, sources paramSources, matrix Params) int {
+	issues, err := lintSuite(paths, sources, matrix)
+	if err != nil {
+		fatal(err)
+	}
+	for _, issue := range issues {
+		color.Red(issue.String())
+	}
+	if len(issues) != 0 {
+		fmt.Printf("%d issues found\n", len(issues))
+		return 1
+	}
+	color.Green("No issues found")
+	return 0
+}
+
+// lintSuite lints every test file in paths for every parameter
+// combination and returns the issues found, without duplicates
+func lintSuite(paths []string, sources paramSources, matrix Params) ([]lintIssue, error) {
+	files, err := collectTestFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+	issues := make([]lintIssue, 0)
+	seen := make(map[lintIssue]bool)
+	for _, file := range files {
+		fileIssues := make([]lintIssue, 0)
+		testConfig, err := loadTestConfig(file, sources)
+		if err != nil {
+			fileIssues = append(fileIssues, lintIssue{File: file, Message: err.Error()})
+		} else if combos, err := testConfig.combinations(matrix); err != nil {
+			fileIssues = append(fileIssues, lintIssue{File: file, Message: err.Error()})
+		} else {
+			for _, combo := range combos {
+				fileIssues = append(fileIssues, lintTest(file, testConfig.withParams(combo))...)
+			}
+		}
+		for _, issue := range fileIssues {
+			if !seen[issue] {
+				seen[issue] = true
+				issues = append(issues, issue)
+			}
+		}
+	}
+	return issues, nil
+}
+
+// lintTest loads a test file strictly and checks it for mistakes that
+// would make it fail or silently do nothing when run
+func lintTest(filePath string, testConfig TestConfig) []lintIssue {
+	fileData, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return []lintIssue{{File: filePath, Message: err.Error()}}
+	}
+	loc := newLocator(fileData)
+
+	test, err := loadTest(filePath, testConfig)
+	if err != nil {
+		return loc.loadIssues(filePath, err)
+	}
+
+	issues := make([]lintIssue, 0)
+	add := func(line, column int, format string, args ...interface{}) {
+		issues = append(issues, lintIssue{filePath, line, column, fmt.Sprintf(format, args...)})
+	}
+
+	subsetPartition, err := partition(test.Config)
+	if err != nil {
+		line, column := loc.key("config", "subset_partition")
+		add(line, column, "%s", err)
+	}
+
+	vars := make(map[string]bool)
+	arrays := make(map[string]bool)
+	for _, phase := range test.phases() {
+		if phase.name == "finally" {
+			/* Finally does not see the variables of the repetitions */
+			vars = make(map[string]bool)
+			arrays = make(map[string]bool)
+		}
+		phasePartition := subsetPartition
+		for _, step := range phase.steps {
+			line, column := loc.item(phase.name, step.source)
+			for _, check := range []func([]Step) error{
+				func(steps []Step) error { return validateSelections(steps, phasePartition, test.Config) },
+				validateWhen,
+				validateStepTypes,
+				func(steps []Step) error { return validateRepartitions(steps, test.Config) },
+			} {
+				if err := check([]Step{step}); err != nil {
+					add(line, column, "step '%s': %s", step.Name, strings.TrimSuffix(err.Error(), " on test step 0"))
+				}
+			}
+			if last := max([]int{step.OnNode, step.EndNode}); last > test.Config.Nodes {
+				add(line, column, "step '%s': selects node %d of a %d node test", step.Name, last, test.Config.Nodes)
+			}
+			for _, message := range lintStep(step, vars, arrays) {
+				add(line, column, "step '%s': %s", step.Name, message)
+			}
+			if step.Repartition != nil && step.partitionSpec(test.Config) != nil {
+				phasePartition, _ = staticPartition(test.Config, step.partitionSpec(test.Config))
+			}
+		}
+	}
+
+	expected := test.Config.Expected
+	if expected == (Expected{}) && hasAssertions(test) {
+		line, column := loc.key("config", "expected")
+		if line == 0 {
+			line, column = loc.key("config")
+		}
+		add(line, column, "expected is empty, the test only passes if no assertion succeeds")
+	}
+	return issues
+}
+
+// lintStep checks the variables a step uses against those defined by
+// earlier steps, then records the ones it defines
+func lintStep(step Step, vars map[string]bool, arrays map[string]bool) []string {
+	messages := make([]string, 0)
+	if step.For != nil {
+		switch {
+		case step.For.IterStructure == "":
+			messages = append(messages, "for without iter_structure")
+		case step.For.IterStructure == "BOUND":
+			if step.For.Number <= 0 {
+				messages = append(messages, "never runs, for BOUND needs a positive number")
+			}
+		case !arrays[step.For.IterStructure]:
+			messages = append(messages, fmt.Sprintf("never runs, no earlier step appends to array %s", step.For.IterStructure))
+		}
+	}
+	for _, input := range step.Inputs {
+		if !vars[input] && !arrays[input] {
+			messages = append(messages, fmt.Sprintf("input %s is not defined by an earlier step", input))
+		}
+	}
+	for _, match := range arrayRefRegex.FindAllStringSubmatch(step.CMD, -1) {
+		if !arrays[match[1]] {
+			messages = append(messages, fmt.Sprintf("array %s is not defined by an earlier step", match[1]))
+		}
+	}
+	if selection := step.Selection; selection != nil {
+		for _, name := range []string{selection.From, selection.NotIn, selection.ComplementOf} {
+			if name != "" && !arrays[name] {
+				messages = append(messages, fmt.Sprintf("selection %s is not saved by an earlier step", name))
+			}
+		}
+	}
+	if step.When != "" {
+		if node, err := parseExpr(step.When); err == nil {
+			for _, name := range node.variables() {
+				if !vars[name] && !arrays[name] && !strings.HasPrefix(name, "previous.") {
+					messages = append(messages, fmt.Sprintf("when: %s is not defined by an earlier step", name))
+				}
+			}
+		}
+	}
+
+	if step.SaveSelection != "" {
+		arrays[step.SaveSelection] = true
+	}
+	for _, output := range step.Outputs {
+		if output.SaveTo != "" {
+			vars[output.SaveTo] = true
+		}
+		if output.AppendTo != "" {
+			arrays[output.AppendTo] = true
+		}
+	}
+	if gen := step.Generate; gen != nil {
+		for _, name := range []string{gen.SavePaths, gen.SaveHashes} {
+			if name != "" {
+				arrays[name] = true
+			}
+		}
+		if gen.SaveRoot != "" {
+			vars[gen.SaveRoot] = true
+		}
+	}
+	return messages
+}
+
+func hasAssertions(test Test) bool {
+	for _, step := range test.Steps {
+		if len(step.Assertions) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// locator finds the position of keys and list items in a YAML file.
+// It only understands block style YAML, which is what tests are written in.
+type locator struct {
+	lines []string
+}
+
+func newLocator(data []byte) locator {
+	return locator{strings.Split(string(data), "\n")}
+}
+
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func isContent(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed != "" && !strings.HasPrefix(trimmed, "#")
+}
+
+// block returns the range of lines nested below the key at line start
+func (loc locator) block(start int) (int, int) {
+	indent := indentation(loc.lines[start])
+	end := start + 1
+	for ; end < len(loc.lines); end++ {
+		if isContent(loc.lines[end]) && indentation(loc.lines[end]) <= indent &&
+			!strings.HasPrefix(strings.TrimSpace(loc.lines[end]), "- ") {
+			break
+		}
+	}
+	return start + 1, end
+}
+
+// key returns the 1-based line and column of a key given by its path
+// from the top level, or 0, 0 if it isn't there
+func (loc locator) key(path ...string) (int, int) {
+	from, to := 0, len(loc.lines)
+	line := -1
+	for _, name := range path {
+		line = -1
+		childIndent := -1
+		for i := from; i < to && line < 0; i++ {
+			if !isContent(loc.lines[i]) {
+				continue
+			}
+			indent := indentation(loc.lines[i])
+			if childIndent < 0 {
+				childIndent = indent
+			}
+			if indent == childIndent && strings.HasPrefix(strings.TrimSpace(loc.lines[i]), name+":") {
+				line = i
+			}
+		}
+		if line < 0 {
+			return 0, 0
+		}
+		from, to = loc.block(line)
+	}
+	return line + 1, indentation(loc.lines[line]) + 1
+}
+
+// item returns the position of the n-th (1-based) entry of the list
+// under a top level key
+func (loc locator) item(key string, n int) (int, int) {
+	line, _ := loc.key(key)
+	if line == 0 || n <= 0 {
+		return line, 0
+	}
+	from, to := loc.block(line - 1)
+	itemIndent := -1
+	for i := from; i < to; i++ {
+		if !strings.HasPrefix(strings.TrimSpace(loc.lines[i]), "-") {
+			continue
+		}
+		indent := indentation(loc.lines[i])
+		if itemIndent < 0 {
+			itemIndent = indent
+		}
+		if indent != itemIndent {
+			continue
+		}
+		if n--; n == 0 {
+			return i + 1, indent + 1
+		}
+	}
+	return line, 0
+}
+
+// loadIssues turns an error from loadTest into issues, using the line
+// numbers reported by the yaml package
+func (loc locator) loadIssues(file string, err error) []lintIssue {
+	messages := []string{err.Error()}
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		messages = typeErr.Errors
+	}
+	issues := make([]lintIssue, 0, len(messages))
+	for _, message := range messages {
+		issue := lintIssue{File: file, Message: message}
+		if match := yamlLineRegex.FindStringSubmatch(message); match != nil {
+			issue.Line, _ = strconv.Atoi(match[1])
+			issue.Message = match[2]
+			if field := yamlFieldRegex.FindStringSubmatch(message); field != nil && issue.Line <= len(loc.lines) {
+				if column := strings.Index(loc.lines[issue.Line-1], field[1]+":"); column >= 0 {
+					issue.Column = column + 1
+				}
+			}
+		}
+		issues = append(issues, issue)
+	}
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].Line < issues[j].Line })
+	return issues
+}
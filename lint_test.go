@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeLintTest(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "kipfs-lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "test.yml")
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file, func() { os.RemoveAll(dir) }
+}
+
+func issueStrings(issues []lintIssue, file string) []string {
+	lines := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		lines = append(lines, strings.TrimPrefix(issue.String(), file+":"))
+	}
+	return lines
+}
+
+// test that misspelled keys are reported with their position
+func TestLintUnknownFields(t *testing.T) {
+	file, cleanup := writeLintTest(t, `name: Typos
+config:
+  nodes: 2
+  times: 1
+steps:
+  - name: Add
+    on_node: 1
+    for:
+      iter_structure: BOUND
+      num: 100
+    cmd: ipfs add -q file
+  - name: Check
+    on_node: 1
+    cmd: echo ok
+    assertion:
+      - line: 0
+        should_be_equal_to: ok
+`)
+	defer cleanup()
+
+	issues := issueStrings(lintTest(file, newTestConfig()), file)
+	expected := []string{
+		"10:7: field num not found in type main.For",
+		"15:5: field assertion not found in type main.Step",
+	}
+	if !reflect.DeepEqual(issues, expected) {
+		t.Fatalf("Unexpected issues:\n%s", strings.Join(issues, "\n"))
+	}
+}
+
+// test the checks run on tests that load
+func TestLintChecks(t *testing.T) {
+	file, cleanup := writeLintTest(t, `name: Mistakes
+config:
+  nodes: 2
+  times: 1
+  expected:
+    successes: 0
+steps:
+  - name: Pin
+    on_node: 1
+    for:
+      iter_structure: HASHES
+    cmd: ipfs pin add ${HASHES[%i]}
+  - name: Add
+    on_node: 3
+    cmd: ipfs add -q file
+    outputs:
+      - line: 0
+        save_to: HASH
+  - name: Cat
+    on_node: 2
+    inputs: [HASH, OTHER]
+    when: HASH != ''
+    cmd: ipfs cat $HASH
+    assertions:
+      - line: 0
+        should_be_equal_to: content
+`)
+	defer cleanup()
+
+	issues := issueStrings(lintTest(file, newTestConfig()), file)
+	expected := []string{
+		"8:3: step 'Pin': never runs, no earlier step appends to array HASHES",
+		"8:3: step 'Pin': array HASHES is not defined by an earlier step",
+		"13:3: step 'Add': selects node 3 of a 2 node test",
+		"19:3: step 'Cat': input OTHER is not defined by an earlier step",
+		"5:3: expected is empty, the test only passes if no assertion succeeds",
+	}
+	if !reflect.DeepEqual(issues, expected) {
+		t.Fatalf("Unexpected issues:\n%s", strings.Join(issues, "\n"))
+	}
+}
+
+// test that the tests shipped with the repository lint cleanly
+func TestLintSelectionTests(t *testing.T) {
+	issues, err := lintSuite([]string{"test_tests/selection_framework/succeedtests", "test_tests/iteration_feature"}, newParamSources(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 0 {
+		t.Fatalf("Unexpected issues: %v", issues)
+	}
+}
+
+// test that the JSON Schema knows every field of the test format
+func TestSchemaMatchesTypes(t *testing.T) {
+	data, err := ioutil.ReadFile("docs/test-schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Properties  map[string]interface{} `json:"properties"`
+		Definitions map[string]struct {
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"definitions"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(name string, properties map[string]interface{}, value interface{}) {
+		typ := reflect.TypeOf(value)
+		fields := make(map[string]bool)
+		for i := 0; i < typ.NumField(); i++ {
+			tag := strings.Split(typ.Field(i).Tag.Get("yaml"), ",")[0]
+			if tag == "" || tag == "-" {
+				continue
+			}
+			fields[tag] = true
+			if _, ok := properties[tag]; !ok {
+				t.Errorf("%s: field %s missing from schema", name, tag)
+			}
+		}
+		for property := range properties {
+			if !fields[property] {
+				t.Errorf("%s: schema property %s is not a field", name, property)
+			}
+		}
+	}
+	check("test", schema.Properties, Test{})
+	for name, value := range map[string]interface{}{
+		"param": ParamSpec{}, "config": Config{}, "expected": Expected{},
+		"subset_partition": SubsetPartition{}, "repartition": SubsetPartition{}, "step": Step{}, "selection": Selection{},
+		"range": Range{}, "percent": Percent{}, "for": For{}, "output": Output{},
+		"assertion": Assertion{}, "generate": Generate{}, "network_partition": NetworkPartition{},
+		"network": NetworkImpairment{}, "chaos": Chaos{}, "chaos_schedule": ChaosSchedule{}, "readiness": Readiness{},
+		"prometheus": PrometheusQuery{}, "sampling": Sampling{}, "grafana": Grafana{},
+	} {
+		check(name, schema.Definitions[name].Properties, value)
+	}
+}
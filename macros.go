@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Library is a YAML file of named step macros.  Tests pull libraries in
+// with `include:` and call a macro with a `use:` step, binding the
+// macro's parameters with `with:`.  Libraries may include other libraries.
+//
+//	macros:
+//	  add_random_files:
+//	    params: [COUNT]
+//	    steps:
+//	      - name: add random files
+//	        for:
+//	          iter_structure: BOUND
+//	          number: {{COUNT}}
+//	        ...
+type Library struct {
+	Include []string         `yaml:"include"`
+	Macros  map[string]Macro `yaml:"macros"`
+}
+
+// Macro is a parameterized list of steps.  Its steps are kept as raw
+// YAML until a call site binds the parameters.
+type Macro struct {
+	Params []string      `yaml:"params"`
+	Steps  []interface{} `yaml:"steps"`
+	file   string
+	/* {{...}} declarations hidden from the YAML parser, by placeholder */
+	declarations []string
+}
+
+// Parameter declarations are swapped for plain placeholders while a
+// library is parsed, since `number: {{COUNT}}` is not valid YAML
+var macroPlaceholderRegex = regexp.MustCompile(`__kipfs_param_([0-9]+)__`)
+var anyDeclarationRegex = regexp.MustCompile(`{{[^}]*}}`)
+
+const maxMacroDepth = 16
+
+// expandMacros loads the libraries included by a test and replaces
+// every `use:` step in all phases with the steps of the macro
+func expandMacros(test *Test, testDir string, params Params) error {
+	macros := make(map[string]Macro)
+	visited := make(map[string]bool)
+	for _, include := range test.Include {
+		if err := loadLibrary(resolveInclude(testDir, include), macros, visited); err != nil {
+			return err
+		}
+	}
+	var err error
+	for _, steps := range []*[]Step{&test.Setup, &test.Steps, &test.Teardown, &test.Finally} {
+		for i := range *steps {
+			(*steps)[i].source = i + 1
+		}
+		*steps, err = expandSteps(*steps, macros, params, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func resolveInclude(dir string, include string) string {
+	if filepath.IsAbs(include) {
+		return include
+	}
+	return filepath.Join(dir, include)
+}
+
+func loadLibrary(path string, macros map[string]Macro, visited map[string]bool) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if visited[absPath] {
+		return nil
+	}
+	visited[absPath] = true
+
+	debug("Loading library " + path)
+	fileData, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	declarations := make([]string, 0)
+	hidden := anyDeclarationRegex.ReplaceAllFunc(fileData, func(declaration []byte) []byte {
+		declarations = append(declarations, string(declaration))
+		return []byte(fmt.Sprintf("__kipfs_param_%d__", len(declarations)-1))
+	})
+
+	var library Library
+	if err := yaml.UnmarshalStrict(hidden, &library); err != nil {
+		return fmt.Errorf("library %s: %s", path, err)
+	}
+	for _, include := range library.Include {
+		if err := loadLibrary(resolveInclude(filepath.Dir(path), include), macros, visited); err != nil {
+			return err
+		}
+	}
+	for name, macro := range library.Macros {
+		if existing, ok := macros[name]; ok {
+			return fmt.Errorf("macro %s defined in both %s and %s", name, existing.file, path)
+		}
+		macro.file = path
+		macro.declarations = declarations
+		macros[name] = macro
+	}
+	return nil
+}
+
+func expandSteps(steps []Step, macros map[string]Macro, params Params, callStack []string) ([]Step, error) {
+	if len(callStack) > maxMacroDepth {
+		return nil, fmt.Errorf("macros nested too deeply: %v", callStack)
+	}
+	expanded := make([]Step, 0, len(steps))
+	for _, step := range steps {
+		if step.Use == "" {
+			expanded = append(expanded, step)
+			continue
+		}
+		if containsString(callStack, step.Use) {
+			return nil, fmt.Errorf("macro %s calls itself: %v", step.Use, append(callStack, step.Use))
+		}
+		macroSteps, err := callMacro(step, macros, params)
+		if err != nil {
+			return nil, err
+		}
+		for i := range macroSteps {
+			macroSteps[i].source = step.source
+		}
+		macroSteps, err = expandSteps(macroSteps, macros, params, append(callStack, step.Use))
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, macroSteps...)
+	}
+	return expanded, nil
+}
+
+func callMacro(call Step, macros map[string]Macro, params Params) ([]Step, error) {
+	macro, ok := macros[call.Use]
+	if !ok {
+		return nil, fmt.Errorf("macro %s not found in included libraries", call.Use)
+	}
+	/* A call site only names the macro and binds its parameters */
+	if !reflect.DeepEqual(call, Step{Name: call.Name, Use: call.Use, With: call.With, source: call.source}) {
+		return nil, fmt.Errorf("step using macro %s may only set name, use and with", call.Use)
+	}
+	for name := range call.With {
+		if !containsString(macro.Params, name) {
+			return nil, fmt.Errorf("macro %s has no parameter %s", call.Use, name)
+		}
+	}
+	for _, name := range macro.Params {
+		if _, ok := call.With[name]; !ok {
+			return nil, fmt.Errorf("macro %s called without parameter %s", call.Use, name)
+		}
+	}
+
+	/* Test parameters are visible in macros, call site bindings win */
+	bound := make(Params)
+	for k, v := range params {
+		bound[k] = v
+	}
+	for k, v := range call.With {
+		bound[k] = v
+	}
+
+	rawSteps, err := yaml.Marshal(macro.Steps)
+	if err != nil {
+		return nil, err
+	}
+	rawSteps = macroPlaceholderRegex.ReplaceAllFunc(rawSteps, func(placeholder []byte) []byte {
+		i, _ := strconv.Atoi(string(macroPlaceholderRegex.FindSubmatch(placeholder)[1]))
+		return []byte(macro.declarations[i])
+	})
+	rawSteps, err = replaceParams(rawSteps, bound)
+	if err != nil {
+		return nil, fmt.Errorf("macro %s (%s): %s", call.Use, macro.file, err)
+	}
+	var steps []Step
+	if err := yaml.UnmarshalStrict(rawSteps, &steps); err != nil {
+		return nil, fmt.Errorf("macro %s (%s): %s", call.Use, macro.file, err)
+	}
+	if call.Name != "" {
+		for i := range steps {
+			steps[i].Name = call.Name + ": " + steps[i].Name
+		}
+	}
+	return steps, nil
+}
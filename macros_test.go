@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// test that the shared cluster macros expand in a real test file
+func TestExpandClusterMacros(t *testing.T) {
+	config := newTestConfig()
+	config.addParams(Params{"N": "3", "Y": "2"})
+	test, err := loadTest("ipfs-cluster/tests/0007-block_minority.yml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(test.Steps) != 8 {
+		t.Fatalf("Expected 8 steps after expansion, got %d", len(test.Steps))
+	}
+	add, check := test.Steps[0], test.Steps[2]
+	if add.Name != "add 2 things to ipfs" || add.For == nil || add.For.Number != 2 {
+		t.Fatalf("COUNT not bound in expanded step: %+v", add)
+	}
+	if add.Outputs[0].AppendTo != "HASH" {
+		t.Fatalf("Expanded step lost its outputs: %+v", add.Outputs)
+	}
+	if len(check.Assertions) != 3 || check.Assertions[1].ShouldBeEqualTo != "3" {
+		t.Fatalf("PEERS not bound in expanded step: %+v", check.Assertions)
+	}
+	if check.CMD == "" || check.OnNode != 1 {
+		t.Fatalf("Expanded step lost its command or selection: %+v", check)
+	}
+
+	subsetPartition, err := partition(test.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validate(test, subsetPartition); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeMacroTest(t *testing.T, dir string, library string, test string) string {
+	if err := ioutil.WriteFile(filepath.Join(dir, "lib.yml"), []byte(library), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testPath := filepath.Join(dir, "test.yml")
+	if err := ioutil.WriteFile(testPath, []byte(test), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return testPath
+}
+
+// test that bad macro calls are reported
+func TestBadMacroCalls(t *testing.T) {
+	dir, err := ioutil.TempDir("", "macros")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	library := `
+macros:
+  echo:
+    params: [WORD]
+    steps:
+      - name: echo {{WORD}}
+        on_node: 1
+        cmd: echo {{WORD}}
+  loop:
+    params: []
+    steps:
+      - use: loop
+`
+	calls := map[string]string{
+		"unknown macro":     "  - use: missing\n",
+		"missing parameter": "  - use: echo\n",
+		"unknown parameter": "  - use: echo\n    with: {WORD: hi, OTHER: x}\n",
+		"extra step fields": "  - use: echo\n    on_node: 2\n    with: {WORD: hi}\n",
+		"recursive macro":   "  - use: loop\n",
+	}
+	for description, call := range calls {
+		testPath := writeMacroTest(t, dir, library, "name: macros\ninclude: [lib.yml]\nsteps:\n"+call)
+		if _, err := loadTest(testPath, newTestConfig()); err == nil {
+			t.Errorf("Expected %s to fail", description)
+		}
+	}
+
+	testPath := writeMacroTest(t, dir, library, "name: macros\ninclude: [lib.yml]\nsteps:\n  - name: greet\n    use: echo\n    with: {WORD: hi}\n")
+	test, err := loadTest(testPath, newTestConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(test.Steps) != 1 || test.Steps[0].Name != "greet: echo hi" || test.Steps[0].CMD != "echo hi" {
+		t.Fatalf("Unexpected expansion: %+v", test.Steps)
+	}
+}
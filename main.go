@@ -6,8 +6,8 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"math/rand"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -36,13 +36,68 @@ const (
 
 // Summary is
 type Summary struct {
-	Start      time.Time
-	End        time.Time
-	Successes  int
-	Failures   int
-	TestsToRun int
-	TestsRan   int
-	Timeouts   int
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Successes  int       `json:"successes"`
+	Failures   int       `json:"failures"`
+	TestsToRun int       `json:"tests_to_run"`
+	TestsRan   int       `json:"tests_ran"`
+	Timeouts   int       `json:"timeouts"`
+	Skipped    int       `json:"skipped"`
+	/* Outcomes of the setup, teardown and finally phases are kept apart
+	   from the test's own assertions and do not count towards Expected */
+	Setup    PhaseSummary `json:"setup"`
+	Teardown PhaseSummary `json:"teardown"`
+	Finally  PhaseSummary `json:"finally"`
+	/* Subset partitions the test ran with, in the order they were made */
+	Partitions []PartitionRecord `json:"partitions,omitempty"`
+	Events     []Event           `json:"events,omitempty"`
+	/* Nodes that failed the readiness checks, whose repetition was skipped */
+	Unhealthy []NodeHealth `json:"unhealthy,omitempty"`
+	/* Resource use of command steps, if they were sampled */
+	Resources []StepResources `json:"resources,omitempty"`
+	/* Durations of the executions of command steps */
+	Timings []StepTiming `json:"timings,omitempty"`
+	/* Grafana dashboard over the time of the test */
+	MetricsLink string `json:"metrics_link,omitempty"`
+	/* Outcomes of each step, over all of its iterations and repetitions */
+	Steps []StepOutcome `json:"steps,omitempty"`
+	/* Image of the first container of the pods */
+	Image string `json:"image,omitempty"`
+}
+
+// PhaseSummary holds the outcomes of the steps of a single test phase
+type PhaseSummary struct {
+	Successes int `json:"successes"`
+	Failures  int `json:"failures"`
+	Timeouts  int `json:"timeouts"`
+	Skipped   int `json:"skipped"`
+}
+
+func (phase *PhaseSummary) add(summary Summary) {
+	phase.Successes += summary.Successes
+	phase.Failures += summary.Failures
+	phase.Timeouts += summary.Timeouts
+	phase.Skipped += summary.Skipped
+}
+
+// StepResult holds the outcomes of all iterations of one step
+type StepResult struct {
+	Name      string
+	Successes int
+	Failures  int
+	Timeouts  int
+	Skipped   int
+}
+
+func stepResultSince(name string, before Summary, after Summary) StepResult {
+	return StepResult{
+		Name:      name,
+		Successes: after.Successes - before.Successes,
+		Failures:  after.Failures - before.Failures,
+		Timeouts:  after.Timeouts - before.Timeouts,
+		Skipped:   after.Skipped - before.Skipped,
+	}
 }
 
 // Output is
@@ -76,6 +131,10 @@ type Step struct {
 	/* New style selection */
 	Selection *Selection `yaml:"selection"`
 	For       *For       `yaml:"for"`
+	/* Iterations are skipped when this expression is false */
+	When string `yaml:"when"`
+	/* Array to save the selected nodes to, for selections of later steps */
+	SaveSelection string `yaml:"save_selection"`
 
 	CMD         string      `yaml:"cmd"`
 	Timeout     int         `yaml:"timeout"`
@@ -83,6 +142,37 @@ type Step struct {
 	Inputs      []string    `yaml:"inputs"`
 	Assertions  []Assertion `yaml:"assertions"`
 	WriteToFile string      `yaml:"write_to_file"`
+	/* Bounds on the durations of the executions of an iteration */
+	MaxDuration string `yaml:"max_duration"`
+	P95Below    string `yaml:"p95_below"`
+
+	/* Create files in the pods instead of running a command */
+	Generate *Generate `yaml:"generate"`
+
+	/* Partition the nodes again for the rest of the phase instead of
+	   running a command.  Without partition_type the partition in the
+	   header is made again. */
+	Repartition *SubsetPartition `yaml:"repartition"`
+	/* Cut the network between subsets, or heal it, instead of running
+	   a command.  The network is healed before teardown in any case. */
+	PartitionNetwork *NetworkPartition `yaml:"partition_network"`
+	HealNetwork      bool              `yaml:"heal_network"`
+	/* Add latency, loss or a bandwidth cap to the network of the selected
+	   nodes, or between subsets.  heal_network removes it. */
+	Network *NetworkImpairment `yaml:"network"`
+	/* Delete pods, restart containers or pause and kill processes of the
+	   selected nodes instead of running a command */
+	Chaos *Chaos `yaml:"chaos"`
+	/* Assert on a Prometheus query over the test window */
+	Prometheus *PrometheusQuery `yaml:"prometheus"`
+
+	/* Call a macro from an included library instead of running a command */
+	Use  string            `yaml:"use"`
+	With map[string]string `yaml:"with"`
+
+	/* Position of the step in its phase in the test file, starting at 1.
+	   Steps expanded from a macro share the position of the call. */
+	source int
 }
 
 /* Selection is used to pick nodes for running commands
@@ -130,11 +220,35 @@ each step takes a selection object which allows
 	    		order: SEQUENTIAL
 	    		start: 1
 	    		percent: 50
+
+   3: Select an explicit list of nodes, or the nodes whose pods match
+      labels, annotations or the kubernetes node they run on.  except
+      removes nodes from any selection.
+      **Example**
+      selection:
+        labels:
+          role: bootstrapper
+        except: [1]
 */
 type Selection struct {
 	Range   *Range   `yaml:"range"`
 	Percent *Percent `yaml:"percent"`
+	Nodes   []int    `yaml:"nodes"` /* Explicit list of nodes */
 	Subsets []int    `yaml:"subset"`
+	/* Nodes left out of the selection, applied before range and percent */
+	Except []int `yaml:"except"`
+	/* Only nodes whose pod has all these labels or annotations, or is
+	   scheduled on this kubernetes node.  Without a range, percent or
+	   nodes list every matching node is selected. */
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+	KubeNode    string            `yaml:"kube_node"`
+	/* Selections saved by earlier steps with save_selection: from only
+	   keeps their nodes, not_in leaves them out and complement_of
+	   selects every node but them */
+	From         string `yaml:"from"`
+	NotIn        string `yaml:"not_in"`
+	ComplementOf string `yaml:"complement_of"`
 }
 
 /* Range is a selection method to choose a sequence of nodes.  The
@@ -170,6 +284,11 @@ type Config struct {
 	GraceShutdown   time.Duration    `yaml:"grace_shutdown"`
 	Expected        Expected         `yaml:"expected"`
 	SubsetPartition *SubsetPartition `yaml:"subset_partition"`
+	Seed            int64            `yaml:"seed"` /* Seeds random node selections, --seed overrides it */
+	Readiness       *Readiness       `yaml:"readiness"`
+	Prometheus      string           `yaml:"prometheus"` /* Base URL of the Prometheus API for queries */
+	Sampling        *Sampling        `yaml:"sampling"`
+	Grafana         *Grafana         `yaml:"grafana"`
 }
 
 /* SubsetParition controls the partitioning of the nodes into
@@ -188,6 +307,7 @@ type SubsetPartition struct {
 	Order            string `yaml:"order"`             /* Either RANDOM or SEQUENTIAL */
 	Percents         []int  `yaml:"percents"`          /* Valid for WEIGHTED */
 	NumberPartitions int    `yaml:"number_partitions"` /* Valid for EVEN */
+	EachRepetition   bool   `yaml:"each_repetition"`   /* Partition again before every repetition */
 }
 
 // Expected is
@@ -197,20 +317,42 @@ type Expected struct {
 	Timeouts  int `yaml:"timeouts"`
 }
 
-// Test is
+// Test is a named list of steps plus optional phases around them.
+// Setup runs before the steps of every repetition, and the steps are
+// skipped if it fails.  Teardown runs after every repetition, even when
+// a step timed out, a fatal error occurred or the run was interrupted.
+// Finally runs once after all repetitions, under the same guarantees.
 type Test struct {
-	Name   string `yaml:"name"`
-	Config Config `yaml:"config"`
-	Steps  []Step `yaml:"steps"`
+	Name     string      `yaml:"name"`
+	Tags     []string    `yaml:"tags"`    /* Used to filter tests in suites */
+	Params   ParamSchema `yaml:"params"`  /* Declared test parameters */
+	Include  []string    `yaml:"include"` /* Step macro libraries */
+	Config   Config      `yaml:"config"`
+	Setup    []Step      `yaml:"setup"`
+	Steps    []Step      `yaml:"steps"`
+	Teardown []Step      `yaml:"teardown"`
+	Finally  []Step      `yaml:"finally"`
 }
 
 // Pod is
 type Pod struct {
 	Metadata struct {
-		Name string `json:"name"`
+		Name        string            `json:"name"`
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
+		/* Set once the pod is being deleted */
+		DeletionTimestamp *string `json:"deletionTimestamp"`
 	} `json:"metadata"`
+	Spec struct {
+		NodeName   string `json:"nodeName"`
+		Containers []struct {
+			Name  string `json:"name"`
+			Image string `json:"image"`
+		} `json:"containers"`
+	} `json:"spec"`
 	Status struct {
 		Phase string `json:"phase"`
+		PodIP string `json:"podIP"`
 	} `json:"status"`
 }
 
@@ -221,17 +363,28 @@ type GetPodsOutput struct {
 
 type TestConfig struct {
 	Params Params `yaml:"params"`
+	/* Parameters given a list or range of values in the config file.
+	   Every combination of their values is run as its own test */
+	Sweeps        map[string][]string `yaml:"-"`
+	MatrixExclude []Params            `yaml:"matrix_exclude"`
+	/* Where each parameter's value came from, for --print-params */
+	Origins map[string]string `yaml:"-"`
 }
 
 func newTestConfig() TestConfig {
 	return TestConfig{
-		Params: make(Params),
+		Params:  make(Params),
+		Sweeps:  make(map[string][]string),
+		Origins: make(map[string]string),
 	}
 }
 
+// addParams sets params, a value set this way stops a parameter from
+// being swept
 func (config TestConfig) addParams(params Params) {
 	for k, v := range params {
 		config.Params[k] = v
+		delete(config.Sweeps, k)
 	}
 }
 
@@ -254,6 +407,7 @@ func loadConfigFile(configPath string) (TestConfig, error) {
 
 func fatal(i interface{}) {
 	fmt.Fprintln(os.Stderr, i)
+	runCleanups()
 	os.Exit(1)
 }
 
@@ -262,7 +416,30 @@ func usage() {
 	fmt.Fprintf(os.Stderr, "  kubernetes-ipfs"+
 		" [--param <name>:<value>,...]"+
 		" [--config <config_file>]"+
-		" <testfile>\n\n")
+		" [--matrix <name>=<value>,...]"+
+		" [--seed <seed>] [--dry-run] [--report <file>]"+
+		" [--pushgateway <url>] [--metrics-addr <address>]"+
+		" [--store <dir>] [--revision <revision>] [--stream]"+
+		" [--list-params] [--print-params]"+
+		" <testfile>\n")
+	fmt.Fprintf(os.Stderr, "  kubernetes-ipfs suite"+
+		" [--include <glob>] [--exclude <glob>]"+
+		" [--tags <tag>,...] [--exclude-tags <tag>,...]"+
+		" [--param <name>:<value>,...]"+
+		" [--config <config_file>]"+
+		" [--matrix <name>=<value>,...]"+
+		" [--seed <seed>] [--dry-run] [--report <file>]"+
+		" [--pushgateway <url>] [--metrics-addr <address>]"+
+		" [--store <dir>] [--revision <revision>] [--stream]"+
+		" <testfile|testdir>...\n")
+	fmt.Fprintf(os.Stderr, "  kubernetes-ipfs lint"+
+		" [--param <name>:<value>,...]"+
+		" [--config <config_file>]"+
+		" [--matrix <name>=<value>,...]"+
+		" <testfile|testdir>...\n")
+	fmt.Fprintf(os.Stderr, "  kubernetes-ipfs compare"+
+		" [--store <dir>] [--threshold <percent>]"+
+		" <run> [<baseline>]\n\n")
 	fmt.Fprintf(os.Stderr, "OPTIONS\n")
 	// print each flag's description
 	flag.PrintDefaults()
@@ -273,52 +450,122 @@ func usage() {
 	fmt.Fprintf(os.Stderr, "\n")
 }
 
+const defaultConfigFile = "<testfile_dir>/config.yml"
+
 func main() {
 	// set usage
 	flag.Usage = usage
+	handleInterrupts()
+
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "compare" {
+		os.Exit(runCompare(args[1:]))
+	}
+	suiteMode := len(args) > 0 && args[0] == "suite"
+	lintMode := len(args) > 0 && args[0] == "lint"
+	if suiteMode || lintMode {
+		args = args[1:]
+	}
 
-	cliParams := make(Params)
-	flag.Var(&cliParams, "param",
+	sources := newParamSources()
+	flag.Var(&sources, "param",
 		`Replace all test parameter instances of <name> with <value>.
-        Separate multiple `+"`<name>=<value>` inputs with ',' or pass this flag multiple times.")
+        Separate multiple `+"`<name>=<value>` inputs with ',' or pass this flag multiple times."+`
+        A <value> of @<file> reads the value from <file>.`)
+
+	flag.Var(&sources.configFiles, "config",
+		"Load test parameters from `<config_file>` (default "+defaultConfigFile+`).
+        May be repeated, later files override earlier ones.`)
 
-	var paramFile string
-	paramFileDummy := "<testfile_dir>/config.yml"
-	flag.StringVar(&paramFile, "config", paramFileDummy,
-		"Load test parameters from `<config_file>`")
+	var suiteOpts suiteOptions
+	if suiteMode {
+		suiteOpts.register(flag.CommandLine)
+	}
+	if !lintMode {
+		flag.Int64Var(&suiteOpts.seed, "seed", 0,
+			"Seed random node selections and partitions with `<seed>` instead of the test's seed")
+		flag.BoolVar(&suiteOpts.dryRun, "dry-run", false,
+			"Print the nodes and commands of every step without touching the cluster")
+		flag.StringVar(&suiteOpts.report, "report", "",
+			"Write the outcome of every test, with its summary, to `<file>` as JSON")
+		flag.StringVar(&suiteOpts.pushgateway, "pushgateway", "",
+			"Push the outcome of every test to the Prometheus Pushgateway at `<url>`")
+		flag.StringVar(&suiteOpts.metricsAddr, "metrics-addr", "",
+			"Serve the outcomes of the tests that finished on `<address>`/metrics during the run")
+		flag.StringVar(&suiteOpts.store, "store", "",
+			"Store the outcome of the run in `<dir>`, for the compare command")
+		flag.StringVar(&suiteOpts.revision, "revision", "",
+			"Record `<revision>` as the revision of the image the tests ran against (default the image name)")
+		flag.BoolVar(&streaming, "stream", false,
+			"Print the output of commands line by line as it arrives, prefixed with the node and pod")
+	}
+	var listParamsMode, printParamsMode bool
+	if !suiteMode && !lintMode {
+		flag.BoolVar(&listParamsMode, "list-params", false,
+			"Print the parameters of the test file and exit")
+		flag.BoolVar(&printParamsMode, "print-params", false,
+			"Print the resolved value of each parameter and where it came from, and exit")
+	}
+
+	suiteOpts.matrix = make(Params)
+	flag.Var(&suiteOpts.matrix, "matrix",
+		`Only run the parameter combinations where <name> has <value>.
+        Separate multiple `+"`<name>=<value>` inputs with ',' or pass this flag multiple times.")
 
 	// parse all args
-	flag.Parse()
+	flag.CommandLine.Parse(args)
 
-	args := append([]string{os.Args[0]}, flag.Args()...)
-	if len(args) != 2 {
+	if suiteMode {
+		if flag.NArg() == 0 {
+			usage()
+			os.Exit(1)
+		}
+		os.Exit(runSuite(flag.Args(), suiteOpts, sources))
+	}
+	if lintMode {
+		if flag.NArg() == 0 {
+			usage()
+			os.Exit(1)
+		}
+		os.Exit(runLint(flag.Args(), sources, suiteOpts.matrix))
+	}
+
+	if flag.NArg() != 1 {
 		// no test file in input, print usage and exit
 		usage()
 		os.Exit(1)
 	}
 
 	// test file should be only arg after parsing flags
-	filePath := args[1]
+	filePath := flag.Arg(0)
 
-	var testConfig TestConfig
-	var err error
-	if paramFile == paramFileDummy {
-		// default params file to test directory (if not specified)
-		testConfig, err = loadConfigFile(filepath.Dir(filePath) + "/config.yml")
-		// if default config not found, print Warning and continue
-		if err != nil {
-			fmt.Printf("Warning: %s\n", err)
+	testConfig, err := loadTestConfig(filePath, sources)
+	if err != nil {
+		fatal(err)
+	}
+
+	if listParamsMode {
+		if err := listParams(filePath, testConfig.Params); err != nil {
+			fatal(err)
 		}
-	} else {
-		testConfig, err = loadConfigFile(paramFile)
-		// if input config file not found, report error/quit
-		if err != nil {
+		os.Exit(0)
+	}
+	if printParamsMode {
+		if err := printParams(filePath, testConfig); err != nil {
 			fatal(err)
 		}
+		os.Exit(0)
 	}
 
-	// combine params in config with CLI input (CLI input has priority)
-	testConfig.addParams(cliParams)
+	// a parameter sweep runs like a suite of one test file
+	combos, err := testConfig.combinations(suiteOpts.matrix)
+	if err != nil {
+		fatal(err)
+	}
+	if len(combos) > 1 {
+		os.Exit(runSuite([]string{filePath}, suiteOpts, sources))
+	}
+	testConfig = testConfig.withParams(combos[0])
 
 	debug("## Loading " + filePath)
 
@@ -327,7 +574,8 @@ func main() {
 		fatal(err)
 	}
 
-	subsetPartition, err := partition(test.Config)
+	test.Config.Seed = resolveSeed(test.Config.Seed, suiteOpts.seed)
+	subsetPartition, err := seededPartition(test.Config)
 	if err != nil {
 		fatal(err)
 	}
@@ -335,8 +583,33 @@ func main() {
 	if err := validate(test, subsetPartition); err != nil {
 		fatal(err)
 	}
-	summary := RunTests(test, subsetPartition)
-	PrintResults(summary, test)
+	if suiteOpts.dryRun {
+		printPlan(test, subsetPartition)
+		os.Exit(0)
+	}
+	metrics := newExporter(suiteOpts.pushgateway, suiteOpts.metricsAddr)
+	metrics.start(&RunResult{File: filePath, Name: test.Name, Seed: test.Config.Seed})
+	summary, err := RunTests(test, subsetPartition)
+	if err != nil {
+		color.Red("## Test stopped: %s", err)
+	}
+	status := finishTest(&summary, test)
+	if err != nil {
+		status = 1
+	}
+	result := &RunResult{File: filePath, Name: test.Name, Seed: test.Config.Seed, Summary: summary, Status: status,
+		Revision: runRevision(suiteOpts.revision, summary), Err: err}
+	metrics.export(result)
+	if suiteOpts.report != "" {
+		if err := writeReport(suiteOpts.report, []*RunResult{result}); err != nil {
+			fatal(err)
+		}
+	}
+	storeRun(suiteOpts.store, []*RunResult{result})
+	if err == errInterrupted {
+		status = 130
+	}
+	os.Exit(status) // Returns success on all tests to OS; this allows for test scripting.
 }
 
 func loadTest(filePath string, testConfig TestConfig) (Test, error) {
@@ -346,12 +619,25 @@ func loadTest(filePath string, testConfig TestConfig) (Test, error) {
 		return test, err
 	}
 
-	testData, err := replaceParams(fileData, testConfig.Params)
+	schema, err := loadParamSchema(fileData)
 	if err != nil {
-		fatal(err)
+		return test, err
+	}
+	params, err := schema.resolve(testConfig.Params)
+	if err != nil {
+		return test, err
+	}
+
+	testData, err := replaceParams(fileData, params)
+	if err != nil {
+		return test, err
+	}
+
+	if err := yaml.UnmarshalStrict([]byte(testData), &test); err != nil {
+		return test, err
 	}
 
-	if err := yaml.Unmarshal([]byte(testData), &test); err != nil {
+	if err := expandMacros(&test, filepath.Dir(filePath), params); err != nil {
 		return test, err
 	}
 
@@ -366,63 +652,359 @@ func validate(test Test, subsetPartition map[int][]int) error {
 	   in the config in order to use the subset selection method to choose nodes later on during
 	   testing  */
 
-	err := validateSelections(test.Steps, subsetPartition, test.Config)
-	if err != nil {
-		color.Red("## Step selections did not validate")
-		return err
+	if readiness := test.Config.Readiness; readiness != nil {
+		if err := readiness.validate(); err != nil {
+			return err
+		}
+	}
+	for _, phase := range test.phases() {
+		err := validateSelections(phase.steps, subsetPartition, test.Config)
+		if err == nil {
+			err = validateWhen(phase.steps)
+		}
+		if err == nil {
+			err = validateStepTypes(phase.steps)
+		}
+		if err == nil {
+			err = validateRepartitions(phase.steps, test.Config)
+		}
+		if err != nil {
+			color.Red("## Step selections did not validate")
+			if phase.name != "steps" {
+				return fmt.Errorf("%s: %s", phase.name, err)
+			}
+			return err
+		}
 	}
 	return nil
 }
 
-func RunTests(test Test, subsetPartition map[int][]int) (summary Summary) {
+// kinds lists the things a step does, of which there may be only one
+func (step Step) kinds() []string {
+	kinds := make([]string, 0, 1)
+	for _, kind := range []struct {
+		name string
+		set  bool
+	}{
+		{"a command", step.CMD != ""},
+		{"generate", step.Generate != nil},
+		{"repartition", step.Repartition != nil},
+		{"partition_network", step.PartitionNetwork != nil},
+		{"heal_network", step.HealNetwork},
+		{"network", step.Network != nil},
+		{"chaos", step.Chaos != nil},
+		{"prometheus", step.Prometheus != nil},
+	} {
+		if kind.set {
+			kinds = append(kinds, kind.name)
+		}
+	}
+	return kinds
+}
+
+// selectsNodes tells whether the step runs on selected nodes rather than
+// acting on the whole test
+func (step Step) selectsNodes() bool {
+	return step.Repartition == nil && step.PartitionNetwork == nil && !step.HealNetwork &&
+		(step.Network == nil || step.Network.Between == nil) && step.Prometheus == nil
+}
+
+/* validateStepTypes checks that each step does exactly one thing */
+func validateStepTypes(steps []Step) error {
+	for idx, step := range steps {
+		if kinds := step.kinds(); len(kinds) > 1 {
+			return validateError(idx, "Step has both "+kinds[0]+" and "+kinds[1])
+		}
+		if step.Chaos != nil {
+			if err := step.Chaos.validate(); err != nil {
+				return validateError(idx, err.Error())
+			}
+		}
+		if err := step.validateDurationBounds(); err != nil {
+			return validateError(idx, err.Error())
+		}
+		if step.Generate == nil {
+			continue
+		}
+		if err := step.Generate.validate(); err != nil {
+			return validateError(idx, "Invalid generate: "+err.Error())
+		}
+	}
+	return nil
+}
+
+type testPhase struct {
+	name  string
+	steps []Step
+}
+
+func (test Test) phases() []testPhase {
+	return []testPhase{
+		{"setup", test.Setup},
+		{"steps", test.Steps},
+		{"teardown", test.Teardown},
+		{"finally", test.Finally},
+	}
+}
+
+func RunTests(test Test, subsetPartition map[int][]int) (Summary, error) {
+	return runTest(test, subsetPartition, func() (*GetPodsOutput, error) {
+		return preparePods(&test.Config)
+	})
+}
+
+// runTest runs every repetition of a test on the pods returned by
+// getTestPods, which is called once per repetition.  An error stops the
+// test after the teardown of its repetition and finally ran, and is
+// returned with the summary so far.
+func runTest(test Test, subsetPartition map[int][]int, getTestPods func() (*GetPodsOutput, error)) (summary Summary, err error) {
 	summary.TestsToRun = test.Config.Times
 	summary.Start = time.Now()
-	var err error
-	for i := 0; i < test.Config.Times; i++ {
-		color.Cyan("## Running test '" + test.Name + "'")
-		if err != nil {
-			fatal(err)
+	color.Cyan("## Using random seed %d", test.Config.Seed)
+	startAnnotations(test)
+	var pods *GetPodsOutput
+
+	/* Finally needs a pod list even if the first repetition dies early,
+	   so it is only registered once pods have been fetched */
+	var finallyDone func()
+	/* Every phase starts from the partition of its repetition, which is
+	   the one in the header unless it is made again for each repetition */
+	repetitionPartition := subsetPartition
+	for i := 0; i < test.Config.Times && err == nil; i++ {
+		if err = checkInterrupted(); err != nil {
+			break
 		}
+		color.Cyan("## Running test '" + test.Name + "'")
 
-		// We'll check for running pods.
-		// In the event we ask the controller to scale, and the pods are just still starting
-		// e.g. If someone cancels the scale-up and restarts right after, then it'll just keep
-		// on doing the same thing.
-		running_nodes, err := getRunningPods(&test.Config)
-		if err != nil {
-			fatal(err)
+		fresh, podsErr := getTestPods()
+		if podsErr != nil {
+			err = podsErr
+			break
 		}
-		if test.Config.Nodes > running_nodes {
-			fmt.Println("Not enough nodes running. Scaling up...")
-			err := scaleTo(&test.Config)
-			if err != nil {
-				fatal(err)
+		if pods == nil {
+			pods = livePods(fresh)
+			if len(pods.Items) != 0 && len(pods.Items[0].Spec.Containers) != 0 {
+				summary.Image = pods.Items[0].Spec.Containers[0].Image
 			}
+		} else {
+			/* Nodes keep their pods across repetitions, and the pods that
+			   were replaced since the last one take over their nodes */
+			remapped, replacements := remapPods(pods, fresh)
+			*pods = *remapped
+			recordReplacements(&summary, "setup", "", replacements)
+		}
+		if finallyDone == nil && len(test.Finally) != 0 {
+			finallyPods := pods
+			finallyDone = pushCleanup("finally", func() {
+				_, _, finallyErr := runPhase("finally", test.Finally, finallyPods, &summary, &summary.Finally, test.Config, copyPartition(subsetPartition), nil, nil)
+				if err == nil {
+					err = finallyErr
+				}
+				summary.Finally.Failures += restoreCluster()
+			})
+		}
+		if spec := test.Config.SubsetPartition; spec != nil && (i == 0 || spec.EachRepetition) {
+			if i > 0 {
+				if err = repartition(repetitionPartition, test.Config, spec); err != nil {
+					break
+				}
+			} else {
+				repetitionPartition = copyPartition(subsetPartition)
+			}
+			record := PartitionRecord{Repetition: i + 1, Subsets: copyPartition(repetitionPartition)}
+			color.Cyan("## Using partition %s", record)
+			summary.Partitions = append(summary.Partitions, record)
 		}
-
-		pods, err := getPods(&test.Config) // Get the pod list after a scale-up
 		color.Cyan("## Using " + strconv.Itoa(test.Config.Nodes) + " nodes for this test")
 		env := make([]string, 0)
 		envArrays := make(map[string][]string)
-		for _, step := range test.Steps {
-			numIters := getStepIterations(step, envArrays)
-			for iter := 0; iter < numIters; iter++ {
-				nodeIndices := selectNodes(step, test.Config, subsetPartition)
-				env, envArrays = handleStep(*pods, &step, &summary, env, envArrays, nodeIndices, iter)
+
+		teardownPartition := copyPartition(repetitionPartition)
+		/* The cluster is restored before teardown, and again after it in
+		   case teardown itself partitions the network or pauses processes */
+		teardownDone := pushCleanup("teardown", func() {
+			summary.Teardown.Failures += restoreCluster()
+			_, _, teardownErr := runPhase("teardown", test.Teardown, pods, &summary, &summary.Teardown, test.Config, teardownPartition, env, envArrays)
+			if err == nil {
+				err = teardownErr
 			}
+			summary.Teardown.Failures += restoreCluster()
+		})
+		if readiness := test.Config.Readiness; readiness != nil {
+			if unhealthy := waitReady(readiness, *pods, test.Config, i+1); len(unhealthy) != 0 {
+				color.Red("## Nodes never became healthy, skipping the repetition")
+				summary.Unhealthy = append(summary.Unhealthy, unhealthy...)
+				teardownDone()
+				summary.TestsRan = summary.TestsRan + 1
+				continue
+			}
+		}
+		setupBefore := summary.Setup
+		env, envArrays, err = runPhase("setup", test.Setup, pods, &summary, &summary.Setup, test.Config, copyPartition(repetitionPartition), env, envArrays)
+		switch {
+		case err != nil:
+		case summary.Setup.Failures > setupBefore.Failures || summary.Setup.Timeouts > setupBefore.Timeouts:
+			color.Red("## Setup failed, skipping test steps")
+		default:
+			env, envArrays, err = runSteps("steps", test.Steps, pods, &summary, test.Config, copyPartition(repetitionPartition), env, envArrays)
 		}
+		teardownDone()
 		summary.TestsRan = summary.TestsRan + 1
 	}
-	return summary
+	if finallyDone != nil {
+		finallyDone()
+	}
+	/* Nothing the test did to the cluster outlives it */
+	summary.Teardown.Failures += restoreCluster()
+	return summary, err
+}
+
+// runPhase runs the steps of a setup, teardown or finally phase and
+// records their outcomes in phaseSummary instead of the test summary
+func runPhase(name string, steps []Step, pods *GetPodsOutput, testSummary *Summary, phaseSummary *PhaseSummary, config Config, subsetPartition map[int][]int, env []string, envArrays map[string][]string) ([]string, map[string][]string, error) {
+	if len(steps) == 0 {
+		return env, envArrays, nil
+	}
+	if envArrays == nil {
+		envArrays = make(map[string][]string)
+	}
+	color.Cyan("## Running %s", name)
+	summary := Summary{TestsRan: testSummary.TestsRan, Start: testSummary.Start}
+	env, envArrays, err := runSteps(name, steps, pods, &summary, config, subsetPartition, env, envArrays)
+	phaseSummary.add(summary)
+	testSummary.Partitions = append(testSummary.Partitions, summary.Partitions...)
+	testSummary.Events = append(testSummary.Events, summary.Events...)
+	testSummary.Resources = append(testSummary.Resources, summary.Resources...)
+	for _, timing := range summary.Timings {
+		testSummary.addDurations(timing.Phase, timing.Step, timing.durations)
+	}
+	for _, outcome := range summary.Steps {
+		testSummary.addStepResult(outcome.Phase, StepResult{outcome.Step, outcome.Successes, outcome.Failures, outcome.Timeouts, outcome.Skipped})
+	}
+	return env, envArrays, err
+}
+
+// runSteps runs the steps of a phase.  A repartition step changes
+// subsetPartition for the steps after it.  A when condition that can't be
+// evaluated stops the phase with an error.
+func runSteps(phase string, steps []Step, pods *GetPodsOutput, summary *Summary, config Config, subsetPartition map[int][]int, env []string, envArrays map[string][]string) ([]string, map[string][]string, error) {
+	var previous StepResult
+	for _, step := range steps {
+		/* An interrupted test stops before its next step, but still runs
+		   all of teardown and finally */
+		if phase != "teardown" && phase != "finally" {
+			if err := checkInterrupted(); err != nil {
+				return env, envArrays, err
+			}
+		}
+		before := *summary
+		start := time.Now()
+		/* Pods deleted since the last step, by the test or anything else,
+		   are replaced on the same nodes before the step uses them.  A pod
+		   that went away makes the commands sent to it fail, so the pods
+		   are only checked again after a step that did not fully succeed */
+		if previous.Failures != 0 || previous.Timeouts != 0 {
+			checkPods(config, pods, summary, phase, step.Name)
+		}
+		if !step.selectsNodes() {
+			switch {
+			case step.Repartition != nil:
+				handleRepartition(&step, summary, config, subsetPartition, phase)
+			case step.PartitionNetwork != nil:
+				handlePartitionNetwork(pods, &step, summary, config, subsetPartition, phase)
+			case step.HealNetwork:
+				handleHealNetwork(&step, summary, phase)
+			case step.Network != nil:
+				handleNetworkBetween(pods, &step, summary, config, subsetPartition, phase)
+			case step.Prometheus != nil:
+				handlePrometheus(&step, summary, config)
+			}
+			previous = stepResultSince(step.Name, before, *summary)
+			summary.addStepResult(phase, previous)
+			exportStepResult(phase, previous)
+			annotateStep(phase, step.Name, repetitionOf(summary, phase), start)
+			continue
+		}
+		numIters := getStepIterations(step, envArrays)
+		if step.SaveSelection != "" {
+			delete(envArrays, step.SaveSelection)
+		}
+		for iter := 0; iter < numIters; iter++ {
+			if step.When != "" {
+				scope := stepScope{env: env, envArrays: envArrays, iter: iter, previous: previous}
+				run, err := evalWhen(step.When, scope)
+				if err != nil {
+					return env, envArrays, fmt.Errorf("Step '%s': when: %s", step.Name, err)
+				}
+				if !run {
+					color.Yellow("### Skipping step %s on iteration %d (when: %s)", step.Name, iter, step.When)
+					summary.Skipped++
+					continue
+				}
+			}
+			nodeIndices := selectNodes(step, config, subsetPartition, pods, envArrays)
+			if step.SaveSelection != "" {
+				saveSelection(envArrays, step.SaveSelection, nodeIndices)
+			}
+			switch {
+			case step.Generate != nil:
+				env, envArrays = handleGenerate(*pods, &step, summary, env, envArrays, nodeIndices)
+			case step.Network != nil:
+				handleNetwork(pods, &step, summary, config, nodeIndices, phase)
+			case step.Chaos != nil:
+				handleChaos(pods, &step, summary, config, subsetPartition, envArrays, nodeIndices, phase)
+			case config.Sampling != nil && len(nodeIndices) != 0:
+				sampler := startSampling(config.Sampling, *pods, nodeIndices)
+				env, envArrays = handleStep(*pods, &step, summary, env, envArrays, nodeIndices, iter, phase)
+				summary.addResources(phase, step.Name, sampler.finish())
+			default:
+				env, envArrays = handleStep(*pods, &step, summary, env, envArrays, nodeIndices, iter, phase)
+			}
+		}
+		previous = stepResultSince(step.Name, before, *summary)
+		summary.addStepResult(phase, previous)
+		exportStepResult(phase, previous)
+		annotateStep(phase, step.Name, repetitionOf(summary, phase), start)
+	}
+	return env, envArrays, nil
+}
+
+// preparePods scales the deployment up if fewer pods than the test
+// needs are running and returns the pod list
+func preparePods(cfg *Config) (*GetPodsOutput, error) {
+	// We'll check for running pods.
+	// In the event we ask the controller to scale, and the pods are just still starting
+	// e.g. If someone cancels the scale-up and restarts right after, then it'll just keep
+	// on doing the same thing.
+	running_nodes, err := getRunningPods(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Nodes > running_nodes {
+		fmt.Println("Not enough nodes running. Scaling up...")
+		err := scaleTo(cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return getPods(cfg) // Get the pod list after a scale-up
 }
 
-func PrintResults(summary Summary, test Test) {
+// finishTest waits for the grace period, prints the summary and returns
+// the exit status for the test
+func finishTest(summary *Summary, test Test) int {
 	fmt.Println(time.Now().String())
 	fmt.Println("Now waiting for " + test.Config.GraceShutdown.String() + " seconds before shutdown...")
 	time.Sleep(test.Config.GraceShutdown * time.Second)
 	summary.End = time.Now()
-	printSummary(summary)
-	os.Exit(evaluateOutcome(summary, test.Config.Expected)) // Returns success on all tests to OS; this allows for test scripting.
+	grafana := grafanaConfig(test.Config)
+	if base := grafana.base(); base != "" {
+		summary.MetricsLink = metricsLink(base, grafana.dashboard(), summary.Start, summary.End)
+	}
+	endAnnotations(*summary)
+	printSummary(*summary)
+	return evaluateOutcome(*summary, test.Config.Expected)
 }
 
 func getSubsetBounds(subset int, numSubsets int, numNodes int) (int, int) {
@@ -458,7 +1040,7 @@ func getStepIterations(step Step, envArrays map[string][]string) int {
 	return numIters
 }
 
-func handleStep(pods GetPodsOutput, step *Step, summary *Summary, env []string, envArrays map[string][]string, nodeIndices []int, iter int) ([]string, map[string][]string) {
+func handleStep(pods GetPodsOutput, step *Step, summary *Summary, env []string, envArrays map[string][]string, nodeIndices []int, iter int, phase string) ([]string, map[string][]string) {
 	color.Cyan("### Running step %s on nodes %v", step.Name, nodeIndices)
 	if len(step.Inputs) != 0 {
 		for _, input := range step.Inputs {
@@ -488,28 +1070,29 @@ func handleStep(pods GetPodsOutput, step *Step, summary *Summary, env []string,
 	}
 
 	color.Magenta("Running parallel on %d nodes on iteration %d.", numNodes, iter)
-	// Command search and replace for index references into array (%i/%s) and
-	r1, _ := regexp.Compile("\\[%s\\]")
-	r2, _ := regexp.Compile("\\[%i\\]")
 
 	// Initialize a channel with depth of number of nodes we're testing on simultaneously
 	outputStrings := make(chan []string)
 	outputErr := make(chan bool)
+	outputDuration := make(chan time.Duration)
+	durations := make([]time.Duration, 0, numNodes)
 	for _, idx := range nodeIndices {
-		command := r1.ReplaceAllString(step.CMD, "["+strconv.Itoa(idx-1)+"]")
-		command = r2.ReplaceAllString(command, "["+strconv.Itoa(iter)+"]")
+		// Command search and replace for index references into array (%i/%s)
+		command := expandCommand(step.CMD, idx, iter)
 		// Hand this channel to the pod runner and let it fill the queue
-		runInPodAsync(pods.Items[idx-1].Metadata.Name, command, tmpEnv, step.Timeout, outputStrings, outputErr)
+		runInPodAsync(idx, pods.Items[idx-1].Metadata.Name, command, tmpEnv, step.Timeout, outputStrings, outputErr, outputDuration)
 	}
 	// Iterate through the queue to pull out results one-by-one
 	// These may be out of order, but is there a better way to do this? Do we need them in order?
 	for j := 0; j < numNodes; j++ {
 		out := <-outputStrings
 		err := <-outputErr
+		duration := <-outputDuration
 		if err {
 			summary.Timeouts++
 			continue // skip handling the output or other assertions since it timed out.
 		}
+		durations = append(durations, duration)
 		if len(step.WriteToFile) != 0 {
 			errWrite := ioutil.WriteFile(step.WriteToFile, []byte(strings.Join(out, "\n")), 0664)
 			if errWrite != nil {
@@ -576,6 +1159,8 @@ func handleStep(pods GetPodsOutput, step *Step, summary *Summary, env []string,
 			}
 		}
 	}
+	assertDurations(step, summary, durations)
+	summary.addDurations(phase, step.Name, durations)
 	return env, envArrays
 }
 
@@ -641,7 +1226,7 @@ func scaleTo(cfg *Config) error {
 	return nil
 }
 
-func runInPodAsync(name string, cmdToRun string, env []string, timeout int, chanStrings chan []string, chanTimeout chan bool) {
+func runInPodAsync(node int, name string, cmdToRun string, env []string, timeout int, chanStrings chan []string, chanTimeout chan bool, chanDuration chan time.Duration) {
 	go func() {
 		var lines []string
 		envString := ""
@@ -656,6 +1241,14 @@ func runInPodAsync(name string, cmdToRun string, env []string, timeout int, chan
 		var errout bytes.Buffer
 		cmd.Stdout = &out
 		cmd.Stderr = &errout
+		// Stream the output as it arrives while still capturing all of it
+		var stream, errstream *lineWriter
+		if streaming {
+			stream, errstream = streamWriters(node, name)
+			cmd.Stdout = io.MultiWriter(&out, stream)
+			cmd.Stderr = io.MultiWriter(&errout, errstream)
+		}
+		start := time.Now()
 		cmd.Start()
 		timeout_reached := false
 
@@ -673,44 +1266,92 @@ func runInPodAsync(name string, cmdToRun string, env []string, timeout int, chan
 		} else {
 			cmd.Wait()
 		}
+		duration := time.Since(start)
 
-		if errout.String() != "" {
+		if stream != nil {
+			stream.Flush()
+			errstream.Flush()
+		} else if errout.String() != "" {
 			fmt.Println(errout.String())
 		}
 		lines = strings.Split(out.String(), "\n")
 		// Feed our output into the channel.
 		chanStrings <- lines
 		chanTimeout <- timeout_reached
+		chanDuration <- duration
 	}()
 }
 
-func selectNodes(step Step, config Config, subsetPartition map[int][]int) []int {
+// runInPodWithInput runs a command in a pod with input as its stdin and
+// reports whether the timeout (in seconds, 0 for none) was reached
+func runInPodWithInput(name string, cmdToRun string, input io.Reader, timeout int) (bool, error) {
+	cmd := exec.Command("kubectl", "exec", "-i", name, "--", "bash", "-c", cmdToRun)
+	var errout bytes.Buffer
+	cmd.Stdin = input
+	cmd.Stderr = &errout
+	if err := cmd.Start(); err != nil {
+		return false, err
+	}
+	var timer *time.Timer
+	fired := make(chan bool, 1)
+	if timeout != 0 {
+		timer = time.AfterFunc(time.Duration(timeout)*time.Second, func() {
+			fired <- true
+			cmd.Process.Kill()
+			color.Red("Command timed out after %d seconds", timeout)
+		})
+	}
+	err := cmd.Wait()
+	/* A timer that can't be stopped any more has fired */
+	timeoutReached := false
+	if timer != nil && !timer.Stop() {
+		timeoutReached = <-fired
+	}
+	if err != nil && errout.Len() != 0 {
+		err = fmt.Errorf("%s: %s", err, strings.TrimSpace(errout.String()))
+	}
+	return timeoutReached, err
+}
+
+func selectNodes(step Step, config Config, subsetPartition map[int][]int, pods *GetPodsOutput, envArrays map[string][]string) []int {
 	var nodes []int
 	switch {
 	case step.Selection == nil:
 		nodes = selectNodesFromOnStep(step)
 	default: /* step.Selection != nil */
-		nodes = selectNodesFromSelection(step, config, subsetPartition)
+		nodes = selectNodesFromSelection(step, config, subsetPartition, pods, envArrays)
 	}
 	return nodes
 }
 
-func selectNodesFromSelection(step Step, config Config, subsetPartition map[int][]int) []int {
-	var nodes []int
-	switch {
-	case step.Selection.Range != nil && step.Selection.Subsets == nil:
-		nodes = selectNodesRange(step, config, makeRange(1, config.Nodes))
-	case step.Selection.Range != nil && step.Selection.Subsets != nil:
-		nodes = selectNodesRange(step, config, subsetPartition[step.Selection.Subsets[0]])
-		for _, subset := range step.Selection.Subsets[1:] {
-			nodes = append(nodes, selectNodesRange(step, config, subsetPartition[subset])...)
+// selectNodesFromSelection selects nodes from each subset, or from all
+// nodes, after leaving out excepted nodes, those whose pods don't match
+// and those ruled out by saved selections.  pods may be nil when they
+// are not known, as in a dry run.
+func selectNodesFromSelection(step Step, config Config, subsetPartition map[int][]int, pods *GetPodsOutput, envArrays map[string][]string) []int {
+	pools := [][]int{makeRange(1, config.Nodes)}
+	if step.Selection.Subsets != nil {
+		pools = make([][]int, 0, len(step.Selection.Subsets))
+		for _, subset := range step.Selection.Subsets {
+			pools = append(pools, subsetPartition[subset])
 		}
-	case step.Selection.Percent != nil && step.Selection.Subsets == nil:
-		nodes = selectNodesPercent(step, config, makeRange(1, config.Nodes))
-	case step.Selection.Percent != nil && step.Selection.Subsets != nil:
-		nodes = selectNodesPercent(step, config, subsetPartition[step.Selection.Subsets[0]])
-		for _, subset := range step.Selection.Subsets[1:] {
-			nodes = append(nodes, selectNodesPercent(step, config, subsetPartition[subset])...)
+	}
+	var nodes []int
+	for _, pool := range pools {
+		pool = step.Selection.narrow(step.Selection.filter(pool, pods), envArrays)
+		switch {
+		case step.Selection.Range != nil:
+			nodes = append(nodes, selectNodesRange(step, config, pool)...)
+		case step.Selection.Percent != nil:
+			nodes = append(nodes, selectNodesPercent(step, config, pool)...)
+		case step.Selection.Nodes != nil:
+			for _, node := range step.Selection.Nodes {
+				if containsInt(pool, node) {
+					nodes = append(nodes, node)
+				}
+			}
+		default:
+			nodes = append(nodes, pool...)
 		}
 	}
 	return nodes
@@ -733,7 +1374,7 @@ func selectNodesRange(step Step, config Config, nodes []int) []int {
 		end := step.Selection.Range.End - 1
 		selection = getRange(nodes, start, end)
 	case random:
-		selection = shuffle(nodes)[0:step.Selection.Range.Number]
+		selection = firstNodes(shuffle(nodes), step.Selection.Range.Number)
 	}
 	return selection
 }
@@ -748,7 +1389,7 @@ func selectNodesPercent(step Step, config Config, nodes []int) []int {
 		end := step.Selection.Percent.Start - 2 + numNodes
 		selection = getRange(nodes, start, end)
 	case random:
-		selection = shuffle(nodes)[0:numNodes]
+		selection = firstNodes(shuffle(nodes), numNodes)
 	}
 	return selection
 }
@@ -759,6 +1400,33 @@ func validateError(stepNum int, errorStr string) error {
 
 func validateSelections(steps []Step, subsetPartition map[int][]int, config Config) error {
 	for idx, step := range steps {
+		if step.Network != nil {
+			if err := step.Network.validate(subsetPartition); err != nil {
+				return validateError(idx, err.Error())
+			}
+		}
+		if !step.selectsNodes() {
+			if step.OnNode > 0 || step.Selection != nil {
+				return validateError(idx, "Step selects nodes but acts on the whole test")
+			}
+			if step.PartitionNetwork != nil {
+				if err := step.PartitionNetwork.validate(subsetPartition); err != nil {
+					return validateError(idx, err.Error())
+				}
+			}
+			if step.Prometheus != nil {
+				if err := step.Prometheus.validate(config); err != nil {
+					return validateError(idx, err.Error())
+				}
+			}
+			/* Later steps of the phase select from the new subsets */
+			if step.Repartition != nil {
+				if spec := step.partitionSpec(config); spec != nil {
+					subsetPartition, _ = staticPartition(config, spec)
+				}
+			}
+			continue
+		}
 		/* Exactly one of on_node or selection per step */
 		if step.OnNode <= 0 && step.Selection == nil {
 			return validateError(idx, "No selection method")
@@ -769,12 +1437,15 @@ func validateSelections(steps []Step, subsetPartition map[int][]int, config Conf
 		if step.OnNode > 0 {
 			continue
 		}
+		if err := step.Selection.validateLists(config); err != nil {
+			return validateError(idx, err.Error())
+		}
 		/* Selection specific verification */
 		switch {
 		/* If method is selection, exactly one selection format is used */
-		case step.Selection.Range == nil && step.Selection.Percent == nil:
+		case step.Selection.formats() == 0 && !step.Selection.selectsMatching():
 			return validateError(idx, "No selection method")
-		case step.Selection.Range != nil && step.Selection.Percent != nil:
+		case step.Selection.formats() > 1:
 			return validateError(idx, "Two selection formats")
 		case step.Selection.Subsets != nil:
 			if subsetPartition == nil {
@@ -791,10 +1462,10 @@ func validateSelections(steps []Step, subsetPartition map[int][]int, config Conf
 			/* Parameters to validate for each subset */
 			checkLengths := make([]int, 0)
 			if step.Selection.Subsets == nil {
-				checkLengths = append(checkLengths, config.Nodes)
+				checkLengths = append(checkLengths, len(step.Selection.filter(makeRange(1, config.Nodes), nil)))
 			} else {
 				for _, subset := range step.Selection.Subsets {
-					checkLengths = append(checkLengths, len(subsetPartition[subset]))
+					checkLengths = append(checkLengths, len(step.Selection.filter(subsetPartition[subset], nil)))
 				}
 			}
 			switch step.Selection.Range.Order {
@@ -823,15 +1494,18 @@ func validateSelections(steps []Step, subsetPartition map[int][]int, config Conf
 			/* Parameters to validate for each subset */
 			checkLengths := make([]int, 0)
 			numNodes := make([]int, 0)
-			if step.Selection.Subsets == nil {
-				checkLengths = append(checkLengths, config.Nodes)
-				numNodes = append(numNodes, int((float64(percent)/100.0)*float64(config.Nodes)))
-			} else {
+			pools := [][]int{makeRange(1, config.Nodes)}
+			if step.Selection.Subsets != nil {
+				pools = pools[:0]
 				for _, subset := range step.Selection.Subsets {
-					checkLengths = append(checkLengths, len(subsetPartition[subset]))
-					numNodes = append(numNodes, int((float64(percent)/100.0)*float64(len(subsetPartition[subset]))))
+					pools = append(pools, subsetPartition[subset])
 				}
 			}
+			for _, pool := range pools {
+				size := len(step.Selection.filter(pool, nil))
+				checkLengths = append(checkLengths, size)
+				numNodes = append(numNodes, int((float64(percent)/100.0)*float64(size)))
+			}
 			switch step.Selection.Percent.Order {
 			case sequential:
 				for i := range checkLengths {
@@ -977,30 +1651,36 @@ func printSummary(summary Summary) {
 	timeouts := strconv.Itoa(summary.Timeouts)
 	fmt.Println("== Successes: " + successes + "/" + failures + " (success/failure)")
 	fmt.Println("== Timeouts: " + timeouts)
-
-	// Get the grafana service dynamically; this will work even for real k8s deployments instead of just minikube
-	var port_out bytes.Buffer
-	port_cmd := exec.Command("kubectl", "get", "service", "grafana", "--namespace=monitoring", "-o", "jsonpath='{.spec.ports[0].nodePort}'")
-	port_cmd.Stdout = &port_out
-	port_cmd.Run()
-	// Ignore this error for now... We handle it in address_cmd
-
-	var address_out bytes.Buffer
-	address_cmd := exec.Command("kubectl", "get", "nodes", "-o", "jsonpath='{.items[0].status.addresses[?(@.type == \"InternalIP\")].address}'")
-	address_cmd.Stdout = &address_out
-	if address_cmd.Run() != nil {
-		// Use fallback address, we weren't able to get the
-		address := strings.Replace(address_out.String(), "'", "", -1)
-		port := strings.Replace(port_out.String(), "'", "", -1)
-
-		metricsLink := fmt.Sprintf("http://%s:%s", address, port)
-		metricsLink += "/dashboard/db/kubernetes-pod-resources?from=" + unixToStr(summary.Start.Unix()) + "&to=" + unixToStr(summary.End.Unix())
+	if summary.Skipped > 0 {
+		fmt.Println("== Skipped: " + strconv.Itoa(summary.Skipped))
+	}
+	printPhaseSummary("Setup", summary.Setup)
+	printPhaseSummary("Teardown", summary.Teardown)
+	printPhaseSummary("Finally", summary.Finally)
+	printPartitions(summary.Partitions)
+	printEvents(summary.Events)
+	printUnhealthy(summary.Unhealthy)
+	printResources(summary.Resources)
+	printTimings(summary.Timings)
+
+	if summary.MetricsLink != "" {
 		fmt.Println("==")
-		fmt.Println("== Metrics: " + metricsLink)
+		fmt.Println("== Metrics: " + summary.MetricsLink)
+	}
+}
+
+func printPhaseSummary(name string, phase PhaseSummary) {
+	if phase == (PhaseSummary{}) {
+		return
 	}
+	fmt.Printf("== %s: %d/%d (success/failure), %d timeouts, %d skipped\n", name, phase.Successes, phase.Failures, phase.Timeouts, phase.Skipped)
 }
 
 func evaluateOutcome(summary Summary, expected Expected) int {
+	if len(summary.Unhealthy) != 0 {
+		color.Red("Nodes never became healthy")
+		return 1
+	}
 	if summary.Successes != expected.Successes || summary.Failures != expected.Failures || summary.Timeouts != expected.Timeouts {
 		color.Set(color.FgRed)
 		fmt.Println("Expectations were not met")
@@ -1027,13 +1707,27 @@ func makeRange(min, max int) []int {
 }
 
 func getRange(raw []int, start int, end int) []int {
+	/* Pods filtered at run time can leave fewer nodes than validated */
+	if end >= len(raw) {
+		end = len(raw) - 1
+	}
+	if start > end {
+		return []int{}
+	}
 	ret := make([]int, end-start+1)
 	copy(ret, raw[start:end+1])
 	return ret
 }
 
+func firstNodes(nodes []int, n int) []int {
+	if n > len(nodes) {
+		n = len(nodes)
+	}
+	return nodes[0:n]
+}
+
 func onePerm(N int) []int {
-	ret := rand.Perm(N)
+	ret := selectionRand.Perm(N)
 	for i := 0; i < len(ret); i++ {
 		ret[i]++
 	}
@@ -1042,7 +1736,7 @@ func onePerm(N int) []int {
 
 func shuffle(ints []int) []int {
 	shuffled := make([]int, len(ints))
-	idxs := rand.Perm(len(ints))
+	idxs := selectionRand.Perm(len(ints))
 	for i, shufi := range idxs {
 		shuffled[i] = ints[shufi]
 	}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/fatih/color"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -69,7 +72,7 @@ func visit(path string, f os.FileInfo, pathErr error) error {
 	color.Cyan("!!! Running test file %s", path)
 	for i, step := range test.Steps {
 		expectedIndices := expected[path][i]
-		actualIndices := selectNodes(step, test.Config, subsetPartition)
+		actualIndices := selectNodes(step, test.Config, subsetPartition, nil, nil)
 		color.Blue("### Running step %s on nodes %v", step.Name, actualIndices)
 		color.Cyan("### Expecting nodes %v", expectedIndices)
 		if len(actualIndices) == 0 {
@@ -143,6 +146,13 @@ func TestValidSelectionFiles(t *testing.T) {
 	expected[testDir+"/percent_subset.yml"][2] = []int{-1, -1}
 	expected[testDir+"/percent_subset.yml"][3] = []int{-1}
 
+	expected[testDir+"/node_list.yml"] = make(map[int][]int)
+	expected[testDir+"/node_list.yml"][0] = []int{1, 4, 5}
+	expected[testDir+"/node_list.yml"][1] = []int{1, 5}
+	expected[testDir+"/node_list.yml"][2] = []int{3, 4, 5}
+	expected[testDir+"/node_list.yml"][3] = []int{-1, -1, -1}
+	expected[testDir+"/node_list.yml"][4] = []int{4, 5}
+
 	err := filepath.Walk(testDir, visit)
 	if err != nil {
 		t.Error(err.Error())
@@ -160,3 +170,187 @@ func slicesEqual(s1, s2 []int) bool {
 	}
 	return true
 }
+
+// checkError checks the error of a table test case: none if message is
+// empty, else one containing message
+func checkError(t *testing.T, subject interface{}, err error, message string) {
+	t.Helper()
+	switch {
+	case message == "" && err != nil:
+		t.Errorf("%+v: unexpected error %s", subject, err)
+	case message != "" && (err == nil || !strings.Contains(err.Error(), message)):
+		t.Errorf("%+v: expected an error containing %q, got %v", subject, message, err)
+	}
+}
+
+// fakeKubectl puts a kubectl on the PATH that lists pods and runs exec'd
+// commands locally, and returns a function that restores the PATH
+func fakeKubectl(t *testing.T, dir string, pods *GetPodsOutput) func() {
+	data, err := json.Marshal(pods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "pods.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	script := `#!/bin/bash
+case "$1" in
+get) cat "` + filepath.Join(dir, "pods.json") + `" ;;
+exec) while [ "$1" != "--" ]; do shift; done; shift; exec "$@" ;;
+esac
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "kubectl"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	return func() { os.Setenv("PATH", path) }
+}
+
+// test that a failed setup skips the steps but not teardown, that finally
+// runs once after all repetitions and that phases stay out of expected
+func TestRunTestPhases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pods := podList("pod-1", "pod-2")
+	defer fakeKubectl(t, dir, pods)()
+
+	log := filepath.Join(dir, "log")
+	logged := func(phase string) string { return "echo " + phase + " >> " + log + "; " }
+	test := Test{
+		Name:   "phases",
+		Config: Config{Nodes: 2, Times: 2, Expected: Expected{Successes: 1}},
+		/* Setup fails on the first repetition only */
+		Setup: []Step{{Name: "setup", OnNode: 1, CMD: logged("setup") + "test -f " + dir + "/ready && echo ok; touch " + dir + "/ready",
+			Assertions: []Assertion{{0, "ok"}}}},
+		Steps:    []Step{{Name: "steps", OnNode: 1, CMD: logged("steps") + "echo ok", Assertions: []Assertion{{0, "ok"}}}},
+		Teardown: []Step{{Name: "teardown", OnNode: 2, CMD: logged("teardown")}},
+		Finally:  []Step{{Name: "finally", OnNode: 1, CMD: logged("finally") + "echo bad", Assertions: []Assertion{{0, "ok"}}}},
+	}
+	summary, err := runTest(test, nil, func() (*GetPodsOutput, error) { return podList("pod-1", "pod-2"), nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(log)
+	if phases := strings.Fields(string(data)); strings.Join(phases, " ") != "setup teardown setup steps teardown finally" {
+		t.Errorf("Unexpected order of phases %v", phases)
+	}
+	if summary.TestsRan != 2 || summary.Setup.Failures != 1 || summary.Setup.Successes != 1 || summary.Finally.Failures != 1 {
+		t.Errorf("Unexpected phase outcomes %+v", summary)
+	}
+	if summary.Successes != 1 || summary.Failures != 0 || evaluateOutcome(summary, test.Config.Expected) != 0 {
+		t.Errorf("Expected phase outcomes to stay out of the test outcome, got %d/%d", summary.Successes, summary.Failures)
+	}
+
+	/* An error stops the test after teardown and finally ran */
+	os.Remove(log)
+	test.Setup = nil
+	test.Steps = []Step{{Name: "broken", OnNode: 1, When: "1 +", CMD: logged("steps")}}
+	summary, err = runTest(test, nil, func() (*GetPodsOutput, error) { return podList("pod-1", "pod-2"), nil })
+	if err == nil || !strings.Contains(err.Error(), "Step 'broken': when") {
+		t.Errorf("Expected the when error, got %v", err)
+	}
+	data, _ = ioutil.ReadFile(log)
+	if phases := strings.Fields(string(data)); strings.Join(phases, " ") != "teardown finally" || summary.TestsRan != 1 {
+		t.Errorf("Unexpected phases %v after %d repetitions", phases, summary.TestsRan)
+	}
+}
+
+// test that pods which are not running, or belong to no node of the test,
+// neither take a node nor fail the steps
+func TestRunTestIgnoresUnusedPods(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unused")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pods := podList("pod-1", "pod-2", "pod-3", "pod-4")
+	pods.Items[0].Status.Phase = "Pending"
+	pods.Items[3].Status.Phase = "Pending"
+	defer fakeKubectl(t, dir, pods)()
+	timeout := podReplaceTimeout
+	podReplaceTimeout = 0
+	defer func() { podReplaceTimeout = timeout }()
+
+	test := Test{
+		Name:   "unused",
+		Config: Config{Nodes: 2, Times: 1},
+		/* The failed step has the pods checked before the next one */
+		Steps: []Step{
+			{Name: "fail", OnNode: 1, CMD: "echo bad", Assertions: []Assertion{{0, "ok"}}},
+			{Name: "pass", OnNode: 2, CMD: "echo ok", Assertions: []Assertion{{0, "ok"}}},
+		},
+	}
+	summary, err := runTest(test, nil, func() (*GetPodsOutput, error) { return getPods(&test.Config) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Successes != 1 || summary.Failures != 1 || len(summary.Events) != 0 {
+		t.Errorf("Unexpected outcome %d/%d with events %v", summary.Successes, summary.Failures, summary.Events)
+	}
+}
+
+// test that an interrupted test skips its steps but still runs teardown and
+// finally
+func TestRunTestInterrupted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "interrupted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer fakeKubectl(t, dir, podList("pod-1"))()
+	saved := interrupted
+	interrupted = make(chan struct{})
+	defer func() { interrupted = saved }()
+
+	log := filepath.Join(dir, "log")
+	logged := func(phase string) string { return "echo " + phase + " >> " + log }
+	test := Test{
+		Name:     "interrupted",
+		Config:   Config{Nodes: 1, Times: 2},
+		Setup:    []Step{{Name: "setup", OnNode: 1, CMD: logged("setup")}},
+		Steps:    []Step{{Name: "steps", OnNode: 1, CMD: logged("steps")}},
+		Teardown: []Step{{Name: "teardown", OnNode: 1, CMD: logged("teardown")}},
+		Finally:  []Step{{Name: "finally", OnNode: 1, CMD: logged("finally")}},
+	}
+	/* The signal comes in once the first repetition got its pods */
+	summary, err := runTest(test, nil, func() (*GetPodsOutput, error) {
+		close(interrupted)
+		return podList("pod-1"), nil
+	})
+	if err != errInterrupted {
+		t.Errorf("Expected the test to be interrupted, got %v", err)
+	}
+	data, _ := ioutil.ReadFile(log)
+	if phases := strings.Fields(string(data)); strings.Join(phases, " ") != "teardown finally" || summary.TestsRan != 1 {
+		t.Errorf("Unexpected phases %v after %d repetitions", phases, summary.TestsRan)
+	}
+}
+
+// test that a command gets its input and that only a command killed by its
+// timeout reports it
+func TestRunInPodWithInput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer fakeKubectl(t, dir, podList("pod-1"))()
+
+	file := filepath.Join(dir, "input")
+	timedOut, err := runInPodWithInput("pod-1", "cat > "+file, strings.NewReader("data"), 10)
+	if data, _ := ioutil.ReadFile(file); timedOut || err != nil || string(data) != "data" {
+		t.Errorf("Unexpected input %q, timeout %v or error %v", data, timedOut, err)
+	}
+	timedOut, err = runInPodWithInput("pod-1", "echo broken >&2; exit 3", strings.NewReader(""), 0)
+	checkError(t, "failed command", err, "exit status 3: broken")
+	if timedOut {
+		t.Errorf("Expected no timeout without one")
+	}
+	if timedOut, _ = runInPodWithInput("pod-1", "sleep 5", strings.NewReader(""), 1); !timedOut {
+		t.Errorf("Expected the command to time out")
+	}
+}
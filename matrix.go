@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParamRange is a sweep over the integers from From to To inclusive
+type ParamRange struct {
+	From int `yaml:"from"`
+	To   int `yaml:"to"`
+	Step int `yaml:"step"`
+}
+
+func (r ParamRange) values() ([]string, error) {
+	step := r.Step
+	if step == 0 {
+		step = 1
+	}
+	if step < 0 || r.To < r.From {
+		return nil, fmt.Errorf("invalid range from %d to %d step %d", r.From, r.To, r.Step)
+	}
+	values := make([]string, 0)
+	for i := r.From; i <= r.To; i += step {
+		values = append(values, strconv.Itoa(i))
+	}
+	return values, nil
+}
+
+// UnmarshalYAML lets config file parameters be a single value, a list
+// of values or a range (`{from: 1, to: 10, step: 3}`).  Parameters
+// with several values become sweeps.
+func (config *TestConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw struct {
+		Params        map[string]interface{} `yaml:"params"`
+		MatrixExclude []Params               `yaml:"matrix_exclude"`
+	}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	config.Params = make(Params)
+	config.Sweeps = make(map[string][]string)
+	config.Origins = make(map[string]string)
+	config.MatrixExclude = raw.MatrixExclude
+	for name, value := range raw.Params {
+		switch v := value.(type) {
+		case []interface{}:
+			values := make([]string, 0, len(v))
+			for _, item := range v {
+				values = append(values, fmt.Sprint(item))
+			}
+			if len(values) == 0 {
+				return fmt.Errorf("parameter %s has an empty list of values", name)
+			}
+			config.Sweeps[name] = values
+		case map[interface{}]interface{}:
+			var r ParamRange
+			if err := unmarshalParamRange(v, &r); err != nil {
+				return fmt.Errorf("parameter %s: %s", name, err)
+			}
+			values, err := r.values()
+			if err != nil {
+				return fmt.Errorf("parameter %s: %s", name, err)
+			}
+			config.Sweeps[name] = values
+		case nil:
+			config.Params[name] = ""
+		default:
+			config.Params[name] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
+
+func unmarshalParamRange(raw map[interface{}]interface{}, r *ParamRange) error {
+	for key, value := range raw {
+		n, ok := value.(int)
+		if !ok {
+			return fmt.Errorf("range %v must be an integer", key)
+		}
+		switch key {
+		case "from":
+			r.From = n
+		case "to":
+			r.To = n
+		case "step":
+			r.Step = n
+		default:
+			return fmt.Errorf("unknown range field %v", key)
+		}
+	}
+	return nil
+}
+
+// combinations returns the values of the swept parameters for every
+// combination of the matrix, leaving out combinations listed under
+// matrix_exclude and those that don't match every value in filter.
+// A config without sweeps has a single, empty combination.
+func (config TestConfig) combinations(filter Params) ([]Params, error) {
+	names := make([]string, 0, len(config.Sweeps))
+	for name := range config.Sweeps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	combos := []Params{{}}
+	for _, name := range names {
+		next := make([]Params, 0, len(combos)*len(config.Sweeps[name]))
+		for _, combo := range combos {
+			for _, value := range config.Sweeps[name] {
+				extended := Params{name: value}
+				for k, v := range combo {
+					extended[k] = v
+				}
+				next = append(next, extended)
+			}
+		}
+		combos = next
+	}
+
+	selected := make([]Params, 0, len(combos))
+	for _, combo := range combos {
+		full := config.withParams(combo).Params
+		if !full.matches(filter) {
+			continue
+		}
+		excluded := false
+		for _, exclude := range config.MatrixExclude {
+			if full.matches(exclude) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			selected = append(selected, combo)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("No parameter combination left to run")
+	}
+	return selected, nil
+}
+
+// withParams returns a copy of the config with params added.  Swept
+// parameters keep the origin of their sweep.
+func (config TestConfig) withParams(params Params) TestConfig {
+	combined := newTestConfig()
+	combined.addParams(config.Params)
+	combined.addParams(params)
+	for name, origin := range config.Origins {
+		combined.Origins[name] = origin
+	}
+	return combined
+}
+
+// matches tells whether params hold every value in subset
+func (params Params) matches(subset Params) bool {
+	for name, value := range subset {
+		if params[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// label formats the parameters of a matrix combination for reports
+func (params Params) label() string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+params[name])
+	}
+	return strings.Join(pairs, " ")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+)
+
+// test that list and range parameters expand into a filtered matrix
+func TestMatrixCombinations(t *testing.T) {
+	config, err := loadConfigFile("testutils/matrix-config.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Params["NUM_TIMES"] != "10" || len(config.Sweeps) != 2 {
+		t.Fatalf("Unexpected config: %+v", config)
+	}
+
+	combos, err := config.combinations(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	/* 2 node counts * 3 file sizes, minus the excluded combination */
+	if len(combos) != 5 {
+		t.Fatalf("Expected 5 combinations, got %d: %v", len(combos), combos)
+	}
+	if combos[0].label() != "FILE_SIZE=10 NUM_NODES=1" || combos[4].label() != "FILE_SIZE=30 NUM_NODES=1" {
+		t.Fatalf("Combinations not in order: %v", combos)
+	}
+
+	combos, err = config.combinations(Params{"NUM_NODES": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(combos) != 2 {
+		t.Fatalf("Expected 2 combinations with NUM_NODES=2, got %v", combos)
+	}
+	if full := config.withParams(combos[1]).Params; full["FILE_SIZE"] != "20" || full["ON_NODE"] != "1" {
+		t.Fatalf("Combination missing fixed parameters: %v", full)
+	}
+	config.Origins["FILE_SIZE"] = "config.yml"
+	if origins := config.withParams(combos[1]).Origins; origins["FILE_SIZE"] != "config.yml" {
+		t.Fatalf("Combination lost the origins of its parameters: %v", origins)
+	}
+
+	if _, err := config.combinations(Params{"NUM_NODES": "3"}); err == nil {
+		t.Fatal("Filter matching nothing should fail")
+	}
+}
+
+// test that setting a swept parameter pins it to one value
+func TestMatrixOverride(t *testing.T) {
+	config, err := loadConfigFile("testutils/matrix-config.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.addParams(Params{"FILE_SIZE": "10"})
+	combos, err := config.combinations(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(combos) != 2 {
+		t.Fatalf("Expected only NUM_NODES to be swept, got %v", combos)
+	}
+}
+
+// test that a config without lists or ranges runs once
+func TestNoMatrix(t *testing.T) {
+	config, err := loadConfigFile("testutils/add-and-gc-config.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	combos, err := config.combinations(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(combos) != 1 || len(combos[0]) != 0 {
+		t.Fatalf("Expected a single empty combination, got %v", combos)
+	}
+}
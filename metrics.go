@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+/* Job of the metrics pushed to the Pushgateway */
+const pushJob = "kubernetes-ipfs"
+
+var labelNameRegex = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// StepOutcome counts the outcomes of a step over all of its iterations
+// and repetitions
+type StepOutcome struct {
+	Phase     string `json:"phase"`
+	Step      string `json:"step"`
+	Successes int    `json:"successes"`
+	Failures  int    `json:"failures"`
+	Timeouts  int    `json:"timeouts"`
+	Skipped   int    `json:"skipped"`
+}
+
+// addStepResult adds the outcome of running a step once to its totals
+func (summary *Summary) addStepResult(phase string, result StepResult) {
+	for i := range summary.Steps {
+		outcome := &summary.Steps[i]
+		if outcome.Phase == phase && outcome.Step == result.Name {
+			outcome.Successes += result.Successes
+			outcome.Failures += result.Failures
+			outcome.Timeouts += result.Timeouts
+			outcome.Skipped += result.Skipped
+			return
+		}
+	}
+	summary.Steps = append(summary.Steps, StepOutcome{phase, result.Name, result.Successes, result.Failures, result.Timeouts, result.Skipped})
+}
+
+// metricFamilies collects samples by metric name, in the order the
+// metrics were first added, so that each family is written once
+type metricFamilies struct {
+	names   []string
+	help    map[string]string
+	samples map[string][]string
+}
+
+func newMetricFamilies() *metricFamilies {
+	return &metricFamilies{help: make(map[string]string), samples: make(map[string][]string)}
+}
+
+func (families *metricFamilies) add(name string, help string, labels []string, value float64) {
+	if _, ok := families.help[name]; !ok {
+		families.names = append(families.names, name)
+		families.help[name] = help
+	}
+	sample := name
+	if len(labels) != 0 {
+		sample += "{" + strings.Join(labels, ",") + "}"
+	}
+	families.samples[name] = append(families.samples[name], fmt.Sprintf("%s %g", sample, value))
+}
+
+func (families *metricFamilies) String() string {
+	var text strings.Builder
+	for _, name := range families.names {
+		fmt.Fprintf(&text, "# HELP %s %s\n# TYPE %s gauge\n", name, families.help[name], name)
+		for _, sample := range families.samples[name] {
+			text.WriteString(sample + "\n")
+		}
+	}
+	return text.String()
+}
+
+func label(name string, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return name + `="` + value + `"`
+}
+
+// testLabels identifies a test run by its name, file and swept parameters
+func testLabels(result *RunResult) []string {
+	labels := []string{label("test", result.Name), label("file", result.File)}
+	names := make([]string, 0, len(result.Matrix))
+	for name := range result.Matrix {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		labels = append(labels, label("param_"+labelNameRegex.ReplaceAllString(name, "_"), result.Matrix[name]))
+	}
+	return labels
+}
+
+func boolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func addStepMetrics(families *metricFamilies, labels []string, steps []StepOutcome) {
+	for _, outcome := range steps {
+		stepLabels := append(append([]string(nil), labels...), label("phase", outcome.Phase), label("step", outcome.Step))
+		families.add("kipfs_step_successes", "Assertions of the step that passed", stepLabels, float64(outcome.Successes))
+		families.add("kipfs_step_failures", "Assertions of the step that failed", stepLabels, float64(outcome.Failures))
+		families.add("kipfs_step_timeouts", "Commands of the step that timed out", stepLabels, float64(outcome.Timeouts))
+	}
+}
+
+// renderMetrics renders the outcomes of test runs and their steps in the
+// Prometheus text format, and the steps that finished so far of the
+// running test, if any
+func renderMetrics(results []*RunResult, running *RunResult) string {
+	families := newMetricFamilies()
+	if running != nil {
+		labels := testLabels(running)
+		families.add("kipfs_test_running", "Whether the test is running", labels, 1)
+		addStepMetrics(families, labels, running.Summary.Steps)
+	}
+	for _, result := range results {
+		labels := testLabels(result)
+		families.add("kipfs_test_passed", "Whether the test met its expectations", labels, boolValue(result.passed()))
+		families.add("kipfs_test_error", "Whether the test could not be loaded, validated or run to its end", labels, boolValue(result.Err != nil))
+		if result.Err != nil {
+			continue
+		}
+		summary := result.Summary
+		families.add("kipfs_test_successes", "Assertions that passed", labels, float64(summary.Successes))
+		families.add("kipfs_test_failures", "Assertions that failed", labels, float64(summary.Failures))
+		families.add("kipfs_test_timeouts", "Commands that timed out", labels, float64(summary.Timeouts))
+		families.add("kipfs_test_skipped", "Iterations skipped by when", labels, float64(summary.Skipped))
+		families.add("kipfs_test_repetitions", "Repetitions that ran", labels, float64(summary.TestsRan))
+		families.add("kipfs_test_duration_seconds", "Time the test took", labels, summary.End.Sub(summary.Start).Seconds())
+		families.add("kipfs_test_end_timestamp_seconds", "Time the test ended", labels, float64(summary.End.Unix()))
+		addStepMetrics(families, labels, summary.Steps)
+		for _, timing := range summary.Timings {
+			stepLabels := append(append([]string(nil), labels...), label("phase", timing.Phase), label("step", timing.Step))
+			families.add("kipfs_step_executions", "Executions of the step that completed", stepLabels, float64(timing.Count))
+			for _, quantile := range []struct {
+				name  string
+				value float64
+			}{{"0", timing.Min}, {"0.5", timing.P50}, {"0.9", timing.P90}, {"0.95", timing.P95}, {"0.99", timing.P99}, {"1", timing.Max}} {
+				families.add("kipfs_step_duration_seconds", "Quantiles of the durations of the executions of the step",
+					append(append([]string(nil), stepLabels...), label("quantile", quantile.name)), quantile.value)
+			}
+		}
+	}
+	return families.String()
+}
+
+// pushPath returns the path of the group of a test run on the Pushgateway,
+// by file, since tests in different files may have the same name.
+// Values are base64 encoded since they may contain slashes.
+func pushPath(result *RunResult) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	path := "/metrics/job/" + pushJob + "/file@base64/" + encode([]byte(result.File)) +
+		"/test@base64/" + encode([]byte(result.Name))
+	if matrix := result.Matrix.label(); matrix != "" {
+		path += "/matrix@base64/" + encode([]byte(matrix))
+	}
+	return path
+}
+
+// exporter publishes the outcomes of tests as they finish, to a
+// Pushgateway and on a /metrics endpoint, which also shows the steps of
+// the running test as they finish
+type exporter struct {
+	pushgateway string
+	client      http.Client
+	mutex       sync.Mutex
+	results     []*RunResult
+	running     *RunResult
+}
+
+// liveExporter is the exporter of the running test, nil while no test is
+// running or metrics are not exported
+var liveExporter *exporter
+
+// newExporter starts serving metrics on addr if it is set, and returns
+// nil if metrics are neither pushed nor served
+func newExporter(pushgateway string, addr string) *exporter {
+	if pushgateway == "" && addr == "" {
+		return nil
+	}
+	e := &exporter{pushgateway: strings.TrimSuffix(pushgateway, "/"), client: http.Client{Timeout: 10 * time.Second}}
+	if addr != "" {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			fatal(fmt.Errorf("Can't serve metrics: %s", err))
+		}
+		color.Cyan("## Serving metrics on http://%s/metrics", listener.Addr())
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", e.serve)
+		go http.Serve(listener, mux)
+	}
+	return e
+}
+
+func (e *exporter) serve(w http.ResponseWriter, r *http.Request) {
+	e.mutex.Lock()
+	text := renderMetrics(e.results, e.running)
+	e.mutex.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, text)
+}
+
+// start publishes the steps of a test as they finish, until the outcome
+// of the test is exported
+func (e *exporter) start(result *RunResult) {
+	if e == nil {
+		return
+	}
+	running := *result
+	running.Summary = Summary{}
+	e.mutex.Lock()
+	e.running = &running
+	e.mutex.Unlock()
+	liveExporter = e
+}
+
+// exportStepResult adds the outcome of a step of the running test
+func exportStepResult(phase string, result StepResult) {
+	e := liveExporter
+	if e == nil {
+		return
+	}
+	e.mutex.Lock()
+	if e.running != nil {
+		e.running.Summary.addStepResult(phase, result)
+	}
+	e.mutex.Unlock()
+}
+
+// export publishes the outcome of a test run.  Failing to push is not
+// fatal, the run goes on.
+func (e *exporter) export(result *RunResult) {
+	if e == nil {
+		return
+	}
+	e.mutex.Lock()
+	e.results = append(e.results, result)
+	e.running = nil
+	e.mutex.Unlock()
+	liveExporter = nil
+	if e.pushgateway == "" {
+		return
+	}
+	req, err := http.NewRequest("PUT", e.pushgateway+pushPath(result), strings.NewReader(renderMetrics([]*RunResult{result}, nil)))
+	if err == nil {
+		req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+		var resp *http.Response
+		if resp, err = e.client.Do(req); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				err = fmt.Errorf("%s", resp.Status)
+			}
+		}
+	}
+	if err != nil {
+		color.Yellow("## Failed to push metrics of %s: %s", result.File, err)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAddStepResult(t *testing.T) {
+	var summary Summary
+	summary.addStepResult("steps", StepResult{"cat", 2, 1, 0, 0})
+	summary.addStepResult("setup", StepResult{"cat", 1, 0, 0, 0})
+	summary.addStepResult("steps", StepResult{"cat", 3, 0, 1, 2})
+	if len(summary.Steps) != 2 || summary.Steps[0] != (StepOutcome{"steps", "cat", 5, 1, 1, 2}) {
+		t.Fatalf("Unexpected outcomes %+v", summary.Steps)
+	}
+}
+
+func exampleResults() []*RunResult {
+	start := time.Unix(1500000000, 0)
+	summary := Summary{Start: start, End: start.Add(90 * time.Second), Successes: 4, Failures: 1, TestsRan: 2}
+	summary.addStepResult("steps", StepResult{Name: "cat", Successes: 4, Failures: 1})
+	summary.addDurations("steps", "cat", seconds(1, 2))
+	return []*RunResult{
+		{File: "tests/cat.yml", Name: `cat "big"`, Matrix: Params{"N": "3", "file-size": "1MB"}, Summary: summary, Status: 1},
+		{File: "tests/broken.yml", Err: errors.New("invalid")},
+	}
+}
+
+func TestRenderMetrics(t *testing.T) {
+	text := renderMetrics(exampleResults(), nil)
+	for _, expected := range []string{
+		"# TYPE kipfs_test_passed gauge\n" +
+			`kipfs_test_passed{test="cat \"big\"",file="tests/cat.yml",param_N="3",param_file_size="1MB"} 0` + "\n" +
+			`kipfs_test_passed{test="",file="tests/broken.yml"} 0` + "\n",
+		`kipfs_test_error{test="",file="tests/broken.yml"} 1`,
+		`kipfs_test_duration_seconds{test="cat \"big\"",file="tests/cat.yml",param_N="3",param_file_size="1MB"} 90`,
+		`kipfs_step_failures{test="cat \"big\"",file="tests/cat.yml",param_N="3",param_file_size="1MB",phase="steps",step="cat"} 1`,
+		`kipfs_step_duration_seconds{test="cat \"big\"",file="tests/cat.yml",param_N="3",param_file_size="1MB",phase="steps",step="cat",quantile="0.5"} 1`,
+		`kipfs_step_duration_seconds{test="cat \"big\"",file="tests/cat.yml",param_N="3",param_file_size="1MB",phase="steps",step="cat",quantile="1"} 2`,
+	} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("Expected %s in:\n%s", expected, text)
+		}
+	}
+	if strings.Count(text, "# TYPE kipfs_test_passed ") != 1 {
+		t.Errorf("Expected a single family for every metric:\n%s", text)
+	}
+	if strings.Contains(text, `kipfs_test_successes{test=""`) {
+		t.Errorf("Expected no outcomes for tests that did not load:\n%s", text)
+	}
+}
+
+func TestExporter(t *testing.T) {
+	var path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			t.Errorf("Unexpected method %s", r.Method)
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		path, body = r.URL.Path, string(data)
+	}))
+	defer server.Close()
+
+	results := exampleResults()
+	metrics := newExporter(server.URL+"/", "")
+	metrics.export(results[0])
+	if path != "/metrics/job/kubernetes-ipfs/file@base64/dGVzdHMvY2F0LnltbA/test@base64/Y2F0ICJiaWci/matrix@base64/Tj0zIGZpbGUtc2l6ZT0xTUI" {
+		t.Errorf("Unexpected path %s", path)
+	}
+	if body != renderMetrics(results[:1], nil) {
+		t.Errorf("Unexpected body %s", body)
+	}
+	metrics.export(results[1])
+
+	recorder := httptest.NewRecorder()
+	metrics.serve(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Body.String() != renderMetrics(results, nil) {
+		t.Errorf("Unexpected metrics %s", recorder.Body.String())
+	}
+
+	var none *exporter
+	none.start(results[0])
+	none.export(results[0])
+	exportStepResult("steps", StepResult{Name: "cat"})
+	if newExporter("", "") != nil {
+		t.Error("Expected no exporter without a pushgateway or address")
+	}
+}
+
+// test that the steps of the running test are served as they finish
+func TestExportRunningTest(t *testing.T) {
+	metrics := newExporter("", "127.0.0.1:0")
+	result := &RunResult{File: "tests/cat.yml", Name: "cat"}
+	metrics.start(result)
+	exportStepResult("setup", StepResult{Name: "add", Successes: 1})
+	exportStepResult("steps", StepResult{Name: "cat", Failures: 2})
+	serve := func() string {
+		recorder := httptest.NewRecorder()
+		metrics.serve(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		return recorder.Body.String()
+	}
+	text := serve()
+	for _, expected := range []string{
+		`kipfs_test_running{test="cat",file="tests/cat.yml"} 1`,
+		`kipfs_step_successes{test="cat",file="tests/cat.yml",phase="setup",step="add"} 1`,
+		`kipfs_step_failures{test="cat",file="tests/cat.yml",phase="steps",step="cat"} 2`,
+	} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("Expected %s in:\n%s", expected, text)
+		}
+	}
+	if strings.Contains(text, "kipfs_test_passed") {
+		t.Errorf("Expected no outcome for the running test:\n%s", text)
+	}
+	metrics.export(result)
+	if text := serve(); strings.Contains(text, "kipfs_test_running") || !strings.Contains(text, "kipfs_test_passed") {
+		t.Errorf("Expected the outcome of the finished test:\n%s", text)
+	}
+	if liveExporter != nil {
+		t.Error("Expected no running test once it is exported")
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// NetworkImpairment slows down the traffic sent by nodes, with netem for
+// latency and loss and tbf for bandwidth.  Without between, it applies to
+// all traffic of the selected nodes.
+type NetworkImpairment struct {
+	Latency string  `yaml:"latency"` /* Delay added to each packet, e.g. 100ms */
+	Jitter  string  `yaml:"jitter"`  /* Random variation of the delay */
+	Loss    float64 `yaml:"loss"`    /* Percent of packets dropped */
+	Rate    string  `yaml:"rate"`    /* Bandwidth cap in tc units, e.g. 1mbit */
+	Device  string  `yaml:"device"`  /* Default eth0 */
+	/* Pairs of subsets whose traffic to each other is impaired, instead
+	   of all traffic of selected nodes */
+	Between [][]int `yaml:"between"`
+}
+
+var rateRegex = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?([kmgt]?(bit|bps))$`)
+
+func (impairment *NetworkImpairment) device() string {
+	if impairment.Device == "" {
+		return "eth0"
+	}
+	return impairment.Device
+}
+
+func (impairment *NetworkImpairment) delays() (time.Duration, time.Duration, error) {
+	var latency, jitter time.Duration
+	var err error
+	if impairment.Latency != "" {
+		if latency, err = time.ParseDuration(impairment.Latency); err != nil {
+			return 0, 0, fmt.Errorf("Invalid latency %s", impairment.Latency)
+		}
+	}
+	if impairment.Jitter != "" {
+		if jitter, err = time.ParseDuration(impairment.Jitter); err != nil {
+			return 0, 0, fmt.Errorf("Invalid jitter %s", impairment.Jitter)
+		}
+	}
+	return latency, jitter, nil
+}
+
+func (impairment *NetworkImpairment) validate(subsetPartition map[int][]int) error {
+	latency, jitter, err := impairment.delays()
+	if err != nil {
+		return err
+	}
+	switch {
+	case latency < 0 || jitter < 0:
+		return errors.New("Network latency and jitter may not be negative")
+	case jitter != 0 && latency == 0:
+		return errors.New("Network jitter without latency")
+	case impairment.Loss < 0 || impairment.Loss > 100:
+		return errors.New("Network loss must be a percent")
+	case impairment.Rate != "" && !rateRegex.MatchString(impairment.Rate):
+		return fmt.Errorf("Invalid network rate %s", impairment.Rate)
+	case latency == 0 && impairment.Loss == 0 && impairment.Rate == "":
+		return errors.New("Network impairment without latency, loss or rate")
+	}
+	if impairment.Between == nil {
+		return nil
+	}
+	if subsetPartition == nil {
+		return errors.New("Network impairment between subsets without partition in header")
+	}
+	for _, pair := range impairment.Between {
+		if len(pair) != 2 || pair[0] == pair[1] {
+			return fmt.Errorf("Invalid pair of subsets %v", pair)
+		}
+		for _, subset := range pair {
+			if subset < 1 || subset > len(subsetPartition) {
+				return fmt.Errorf("Network impairment has invalid subset %d", subset)
+			}
+		}
+	}
+	return nil
+}
+
+// String describes the impairment for logs and reports
+func (impairment *NetworkImpairment) String() string {
+	parts := make([]string, 0)
+	if impairment.Latency != "" {
+		parts = append(parts, "latency "+impairment.Latency)
+	}
+	if impairment.Jitter != "" {
+		parts = append(parts, "jitter "+impairment.Jitter)
+	}
+	if impairment.Loss != 0 {
+		parts = append(parts, fmt.Sprintf("loss %g%%", impairment.Loss))
+	}
+	if impairment.Rate != "" {
+		parts = append(parts, "rate "+impairment.Rate)
+	}
+	for _, pair := range impairment.Between {
+		parts = append(parts, fmt.Sprintf("between subsets %d and %d", pair[0], pair[1]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// destinations returns, for every node of a pair of subsets, the nodes
+// it sends impaired traffic to
+func (impairment *NetworkImpairment) destinations(subsetPartition map[int][]int) map[int][]int {
+	destinations := make(map[int][]int)
+	for _, pair := range impairment.Between {
+		for i, subset := range pair {
+			other := subsetPartition[pair[1-i]]
+			for _, node := range subsetPartition[subset] {
+				for _, destination := range other {
+					if !containsInt(destinations[node], destination) {
+						destinations[node] = append(destinations[node], destination)
+					}
+				}
+			}
+		}
+	}
+	for _, nodes := range destinations {
+		sort.Ints(nodes)
+	}
+	return destinations
+}
+
+/* tc takes times without the µ Go prints for microseconds */
+func tcTime(duration time.Duration) string {
+	return fmt.Sprintf("%dus", duration.Nanoseconds()/1000)
+}
+
+// impairCommand returns the command that impairs the traffic a pod sends
+// to the given addresses, or all of its traffic if there are none,
+// replacing any earlier impairment.  Impaired traffic goes through the
+// fourth band of a prio qdisc, which the default priority map leaves
+// unused.
+func impairCommand(impairment *NetworkImpairment, destinations []string) string {
+	device := impairment.device()
+	latency, jitter, _ := impairment.delays()
+	netem := "netem"
+	if latency != 0 {
+		netem += " delay " + tcTime(latency)
+		if jitter != 0 {
+			netem += " " + tcTime(jitter)
+		}
+	}
+	if impairment.Loss != 0 {
+		netem += fmt.Sprintf(" loss %g%%", impairment.Loss)
+	}
+	lines := []string{
+		fmt.Sprintf("tc qdisc del dev %s root 2>/dev/null || true", device),
+		"set -e",
+		fmt.Sprintf("tc qdisc add dev %s root handle 1: prio bands 4", device),
+		fmt.Sprintf("tc qdisc add dev %s parent 1:4 handle 40: %s", device, netem),
+	}
+	if impairment.Rate != "" {
+		lines = append(lines, fmt.Sprintf("tc qdisc add dev %s parent 40:1 handle 41: tbf rate %s burst 32kbit latency 400ms", device, impairment.Rate))
+	}
+	if len(destinations) == 0 {
+		destinations = []string{"0.0.0.0/0"}
+	}
+	for _, destination := range destinations {
+		if !strings.Contains(destination, "/") {
+			destination += "/32"
+		}
+		lines = append(lines, fmt.Sprintf("tc filter add dev %s parent 1: protocol ip prio 1 u32 match ip dst %s flowid 1:4", device, destination))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// handleNetwork impairs all traffic of the selected nodes
+func handleNetwork(pods *GetPodsOutput, step *Step, summary *Summary, config Config, nodeIndices []int, phase string) {
+	color.Cyan("### Impairing the network of nodes %v: %s", nodeIndices, step.Network)
+	commands := make(map[int]string)
+	for _, node := range nodeIndices {
+		commands[node] = impairCommand(step.Network, nil)
+	}
+	impairNetwork(pods, step, summary, config, nodeIndices, commands, phase)
+}
+
+// handleNetworkBetween impairs the traffic between pairs of subsets
+func handleNetworkBetween(pods *GetPodsOutput, step *Step, summary *Summary, config Config, subsetPartition map[int][]int, phase string) {
+	color.Cyan("### Impairing the network: %s", step.Network)
+	commands := make(map[int]string)
+	nodes := make([]int, 0)
+	for node, destinations := range step.Network.destinations(subsetPartition) {
+		ips, err := podIPs(*pods, destinations)
+		if err != nil {
+			color.Red("Failed to impair the network: %s", err)
+			summary.Failures++
+			return
+		}
+		commands[node] = impairCommand(step.Network, ips)
+		nodes = append(nodes, node)
+	}
+	sort.Ints(nodes)
+	impairNetwork(pods, step, summary, config, nodes, commands, phase)
+}
+
+func impairNetwork(pods *GetPodsOutput, step *Step, summary *Summary, config Config, nodes []int, commands map[int]string, phase string) {
+	alterNetwork(pods, config).devices[step.Network.device()] = true
+	summary.addEvent(phase, step.Name, "network", nodes, step.Network.String())
+	timeouts, errs := runOnPods(*pods, nodes, commands, step.Timeout)
+	summary.Timeouts += timeouts
+	for _, err := range errs {
+		color.Red("Failed to impair the network: %s", err)
+		summary.Failures++
+	}
+}
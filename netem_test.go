@@ -0,0 +1,88 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateNetworkImpairment(t *testing.T) {
+	subsetPartition := map[int][]int{1: {1, 2}, 2: {3}, 3: {4}}
+	for _, c := range []struct {
+		impairment NetworkImpairment
+		message    string
+	}{
+		{NetworkImpairment{Latency: "100ms", Jitter: "10ms", Loss: 0.5, Rate: "1mbit"}, ""},
+		{NetworkImpairment{Rate: "512kbit", Between: [][]int{{1, 2}, {1, 3}}}, ""},
+		{NetworkImpairment{}, "without latency, loss or rate"},
+		{NetworkImpairment{Latency: "soon"}, "Invalid latency"},
+		{NetworkImpairment{Jitter: "10ms"}, "jitter without latency"},
+		{NetworkImpairment{Loss: 120}, "must be a percent"},
+		{NetworkImpairment{Rate: "fast"}, "Invalid network rate"},
+		{NetworkImpairment{Loss: 1, Between: [][]int{{1, 1}}}, "Invalid pair"},
+		{NetworkImpairment{Loss: 1, Between: [][]int{{1, 4}}}, "invalid subset 4"},
+	} {
+		err := c.impairment.validate(subsetPartition)
+		checkError(t, c.impairment, err, c.message)
+	}
+	between := NetworkImpairment{Loss: 1, Between: [][]int{{1, 2}}}
+	if err := between.validate(nil); err == nil {
+		t.Error("Impairment between subsets without a partition should not validate")
+	}
+}
+
+// test that pairs of subsets impair the traffic from each side to the other
+func TestNetworkDestinations(t *testing.T) {
+	subsetPartition := map[int][]int{1: {2, 1}, 2: {3}, 3: {4}}
+	impairment := NetworkImpairment{Between: [][]int{{1, 2}, {3, 1}}}
+	expected := map[int][]int{1: {3, 4}, 2: {3, 4}, 3: {1, 2}, 4: {1, 2}}
+	if destinations := impairment.destinations(subsetPartition); !reflect.DeepEqual(destinations, expected) {
+		t.Fatalf("Unexpected destinations: %v", destinations)
+	}
+	if impairment.String() != "between subsets 1 and 2, between subsets 3 and 1" {
+		t.Fatalf("Unexpected description: %s", impairment.String())
+	}
+}
+
+func TestImpairCommand(t *testing.T) {
+	impairment := &NetworkImpairment{Latency: "100ms", Jitter: "1.5ms", Loss: 2.5, Rate: "1mbit", Device: "eth1"}
+	command := impairCommand(impairment, nil)
+	for _, line := range []string{
+		"tc qdisc del dev eth1 root 2>/dev/null || true",
+		"tc qdisc add dev eth1 root handle 1: prio bands 4",
+		"tc qdisc add dev eth1 parent 1:4 handle 40: netem delay 100000us 1500us loss 2.5%",
+		"tc qdisc add dev eth1 parent 40:1 handle 41: tbf rate 1mbit burst 32kbit latency 400ms",
+		"match ip dst 0.0.0.0/0 flowid 1:4",
+	} {
+		if !strings.Contains(command, line) {
+			t.Errorf("Missing %q in:\n%s", line, command)
+		}
+	}
+
+	command = impairCommand(&NetworkImpairment{Loss: 1}, []string{"10.0.0.3", "10.0.0.4"})
+	if strings.Contains(command, "tbf") || strings.Contains(command, "delay") || strings.Contains(command, "0.0.0.0/0") {
+		t.Errorf("Unexpected impairment:\n%s", command)
+	}
+	for _, line := range []string{
+		"netem loss 1%",
+		"tc filter add dev eth0 parent 1: protocol ip prio 1 u32 match ip dst 10.0.0.3/32 flowid 1:4",
+		"match ip dst 10.0.0.4/32 flowid 1:4",
+	} {
+		if !strings.Contains(command, line) {
+			t.Errorf("Missing %q in:\n%s", line, command)
+		}
+	}
+}
+
+// test that events know their repetition, except in finally
+func TestAddEvent(t *testing.T) {
+	summary := Summary{TestsRan: 2}
+	summary.addEvent("steps", "slow", "network", []int{1}, "latency 100ms")
+	summary.addEvent("finally", "heal", "heal_network", nil, "")
+	if summary.Events[0].Repetition != 3 || summary.Events[0].Detail != "latency 100ms" {
+		t.Errorf("Unexpected event: %+v", summary.Events[0])
+	}
+	if summary.Events[1].Repetition != 0 || summary.Events[1].Phase != "finally" {
+		t.Errorf("Unexpected event: %+v", summary.Events[1])
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// partitionChain holds the rules of a network partition in every pod, so
+// that healing only has to remove it
+const partitionChain = "KIPFS_PARTITION"
+
+// NetworkPartition cuts the network between groups of subsets of the
+// subset partition.  Nodes of the same island still reach each other.
+type NetworkPartition struct {
+	/* Lists of subsets that form an island.  Every subset left out is an
+	   island of its own, so by default each subset is isolated. */
+	Islands [][]int `yaml:"islands"`
+}
+
+// alteredNetwork is the network as partitioned or impaired by steps.
+// heal runs once, from a heal_network step, the teardown or the exit
+// cleanups.
+type alteredNetwork struct {
+	pods     *GetPodsOutput
+	nodes    []int
+	devices  map[string]bool /* Devices with impairments */
+	heal     func()
+	failures int
+}
+
+// altered is the altered network, nil if steps left it alone
+var altered *alteredNetwork
+
+// alterNetwork returns the altered network, registering its healing the
+// first time a step alters it
+func alterNetwork(pods *GetPodsOutput, config Config) *alteredNetwork {
+	if altered == nil {
+		net := &alteredNetwork{pods: pods, nodes: makeRange(1, config.Nodes), devices: make(map[string]bool)}
+		net.heal = pushCleanup("network heal", func() {
+			net.failures = healNetwork(net.pods, net.nodes, net.devices)
+		})
+		altered = net
+	}
+	return altered
+}
+
+// islands returns the nodes of each island
+func (np *NetworkPartition) islands(subsetPartition map[int][]int) [][]int {
+	listed := make(map[int]bool)
+	islands := make([][]int, 0)
+	for _, subsets := range np.Islands {
+		nodes := make([]int, 0)
+		for _, subset := range subsets {
+			listed[subset] = true
+			nodes = append(nodes, subsetPartition[subset]...)
+		}
+		islands = append(islands, nodes)
+	}
+	for subset := 1; subset <= len(subsetPartition); subset++ {
+		if !listed[subset] {
+			islands = append(islands, append([]int(nil), subsetPartition[subset]...))
+		}
+	}
+	for _, nodes := range islands {
+		sort.Ints(nodes)
+	}
+	return islands
+}
+
+func (np *NetworkPartition) validate(subsetPartition map[int][]int) error {
+	if subsetPartition == nil {
+		return errors.New("Network partition without partition in header")
+	}
+	listed := make(map[int]bool)
+	for _, subsets := range np.Islands {
+		if len(subsets) == 0 {
+			return errors.New("Network partition has an empty island")
+		}
+		for _, subset := range subsets {
+			if subset < 1 || subset > len(subsetPartition) {
+				return fmt.Errorf("Network partition has invalid subset %d", subset)
+			}
+			if listed[subset] {
+				return fmt.Errorf("Subset %d is on two islands", subset)
+			}
+			listed[subset] = true
+		}
+	}
+	if len(np.islands(subsetPartition)) < 2 {
+		return errors.New("Network partition leaves a single island")
+	}
+	return nil
+}
+
+// partitionCommand returns the command that makes a pod drop all traffic
+// from and to the given addresses, replacing any earlier partition
+func partitionCommand(blocked []string) string {
+	lines := []string{
+		"set -e",
+		fmt.Sprintf("iptables -N %[1]s 2>/dev/null || iptables -F %[1]s", partitionChain),
+		fmt.Sprintf("iptables -C INPUT -j %[1]s 2>/dev/null || iptables -I INPUT -j %[1]s", partitionChain),
+		fmt.Sprintf("iptables -C OUTPUT -j %[1]s 2>/dev/null || iptables -I OUTPUT -j %[1]s", partitionChain),
+	}
+	for _, ip := range blocked {
+		lines = append(lines,
+			fmt.Sprintf("iptables -A %s -s %s -j DROP", partitionChain, ip),
+			fmt.Sprintf("iptables -A %s -d %s -j DROP", partitionChain, ip))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// healCommand removes the rules of partitionCommand and the impairments
+// of the devices, if there are any
+func healCommand(devices map[string]bool) string {
+	command := fmt.Sprintf("iptables -D INPUT -j %[1]s 2>/dev/null; iptables -D OUTPUT -j %[1]s 2>/dev/null; "+
+		"iptables -F %[1]s 2>/dev/null; iptables -X %[1]s 2>/dev/null; ", partitionChain)
+	names := make([]string, 0, len(devices))
+	for device := range devices {
+		names = append(names, device)
+	}
+	sort.Strings(names)
+	for _, device := range names {
+		command += fmt.Sprintf("tc qdisc del dev %s root 2>/dev/null; ", device)
+	}
+	return command + "true"
+}
+
+// podIPs returns the IP addresses of the pods of nodes
+func podIPs(pods GetPodsOutput, nodes []int) ([]string, error) {
+	ips := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		ip := pods.Items[node-1].Status.PodIP
+		if ip == "" {
+			return nil, fmt.Errorf("node %d has no IP address", node)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// runOnPods runs a command on the pods of nodes in parallel and returns
+// the number of pods that timed out and the errors of those that failed
+func runOnPods(pods GetPodsOutput, nodes []int, commands map[int]string, timeout int) (int, []error) {
+	type podResult struct {
+		timedOut bool
+		err      error
+	}
+	results := make(chan podResult)
+	for _, node := range nodes {
+		go func(node int) {
+			name := pods.Items[node-1].Metadata.Name
+			timedOut, err := runInPodWithInput(name, commands[node], nil, timeout)
+			if err != nil {
+				err = fmt.Errorf("node %d (%s): %s", node, name, err)
+			}
+			results <- podResult{timedOut, err}
+		}(node)
+	}
+	timeouts := 0
+	errs := make([]error, 0)
+	for range nodes {
+		result := <-results
+		switch {
+		case result.timedOut:
+			timeouts++
+		case result.err != nil:
+			errs = append(errs, result.err)
+		}
+	}
+	return timeouts, errs
+}
+
+func handlePartitionNetwork(pods *GetPodsOutput, step *Step, summary *Summary, config Config, subsetPartition map[int][]int, phase string) {
+	islands := step.PartitionNetwork.islands(subsetPartition)
+	color.Cyan("### Partitioning the network into islands %v", islands)
+	commands := make(map[int]string)
+	nodes := make([]int, 0)
+	for i, island := range islands {
+		others := make([]int, 0)
+		for j, other := range islands {
+			if i != j {
+				others = append(others, other...)
+			}
+		}
+		blocked, err := podIPs(*pods, others)
+		if err != nil {
+			color.Red("Failed to partition the network: %s", err)
+			summary.Failures++
+			return
+		}
+		for _, node := range island {
+			commands[node] = partitionCommand(blocked)
+			nodes = append(nodes, node)
+		}
+	}
+
+	alterNetwork(pods, config)
+	summary.addEvent(phase, step.Name, "partition_network", nil, fmt.Sprintf("islands %v", islands))
+	timeouts, errs := runOnPods(*pods, nodes, commands, step.Timeout)
+	summary.Timeouts += timeouts
+	for _, err := range errs {
+		color.Red("Failed to partition the network: %s", err)
+		summary.Failures++
+	}
+}
+
+func handleHealNetwork(step *Step, summary *Summary, phase string) {
+	if altered == nil {
+		color.Yellow("### The network is neither partitioned nor impaired")
+		return
+	}
+	summary.addEvent(phase, step.Name, "heal_network", nil, "")
+	summary.Failures += healAlteredNetwork()
+}
+
+// healAlteredNetwork heals the network if steps altered it and returns
+// the number of pods that failed to heal
+func healAlteredNetwork() int {
+	if altered == nil {
+		return 0
+	}
+	net := altered
+	altered = nil
+	net.heal()
+	return net.failures
+}
+
+// healNetwork removes partitions and impairments from the pods of nodes
+// and returns the number of pods it failed on
+func healNetwork(pods *GetPodsOutput, nodes []int, devices map[string]bool) int {
+	color.Cyan("### Healing the network of nodes %v", nodes)
+	commands := make(map[int]string)
+	for _, node := range nodes {
+		commands[node] = healCommand(devices)
+	}
+	_, errs := runOnPods(*pods, nodes, commands, 0)
+	for _, err := range errs {
+		color.Red("Failed to heal the network: %s", err)
+	}
+	return len(errs)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// test that islands group subsets and isolate the subsets left out
+func TestNetworkIslands(t *testing.T) {
+	subsetPartition := map[int][]int{1: {4, 1}, 2: {2}, 3: {6, 3}, 4: {5}}
+	np := NetworkPartition{}
+	if islands := np.islands(subsetPartition); !reflect.DeepEqual(islands, [][]int{{1, 4}, {2}, {3, 6}, {5}}) {
+		t.Fatalf("Unexpected default islands: %v", islands)
+	}
+	np.Islands = [][]int{{3, 1}}
+	if islands := np.islands(subsetPartition); !reflect.DeepEqual(islands, [][]int{{1, 3, 4, 6}, {2}, {5}}) {
+		t.Fatalf("Unexpected islands: %v", islands)
+	}
+	if !reflect.DeepEqual(subsetPartition[1], []int{4, 1}) {
+		t.Fatal("Computing islands changed the partition")
+	}
+
+	for _, c := range []struct {
+		islands [][]int
+		message string
+	}{
+		{[][]int{{1, 2}}, ""},
+		{[][]int{{1, 2, 3}}, "single island"},
+		{[][]int{{1}, {}}, "empty island"},
+		{[][]int{{1}, {4}}, "invalid subset 4"},
+		{[][]int{{1}, {1, 2}}, "Subset 1 is on two islands"},
+	} {
+		err := (&NetworkPartition{Islands: c.islands}).validate(map[int][]int{1: {1}, 2: {2}, 3: {3}})
+		checkError(t, c.islands, err, c.message)
+	}
+	if err := np.validate(nil); err == nil {
+		t.Error("Network partition without a subset partition should not validate")
+	}
+}
+
+// test that the partition rules go into their own chain and drop both ways
+func TestPartitionCommand(t *testing.T) {
+	command := partitionCommand([]string{"10.0.0.2", "10.0.0.3"})
+	for _, rule := range []string{
+		"iptables -N KIPFS_PARTITION 2>/dev/null || iptables -F KIPFS_PARTITION",
+		"iptables -I INPUT -j KIPFS_PARTITION",
+		"iptables -I OUTPUT -j KIPFS_PARTITION",
+		"iptables -A KIPFS_PARTITION -s 10.0.0.2 -j DROP",
+		"iptables -A KIPFS_PARTITION -d 10.0.0.3 -j DROP",
+	} {
+		if !strings.Contains(command, rule) {
+			t.Errorf("Missing rule %q in:\n%s", rule, command)
+		}
+	}
+	heal := healCommand(map[string]bool{"eth0": true})
+	if !strings.Contains(heal, "iptables -X KIPFS_PARTITION") || !strings.Contains(heal, "tc qdisc del dev eth0 root") {
+		t.Errorf("Heal command does not remove the chain and impairments: %s", heal)
+	}
+	if healAlteredNetwork() != 0 {
+		t.Error("Healing an unaltered network should do nothing")
+	}
+}
+
+// test that network steps act on the whole test and do nothing else
+func TestValidateNetworkSteps(t *testing.T) {
+	config := Config{Nodes: 4}
+	subsetPartition := map[int][]int{1: {1, 2}, 2: {3, 4}}
+	for _, c := range []struct {
+		step    Step
+		message string
+	}{
+		{Step{PartitionNetwork: &NetworkPartition{}}, ""},
+		{Step{HealNetwork: true}, ""},
+		{Step{PartitionNetwork: &NetworkPartition{}, OnNode: 1}, "acts on the whole test"},
+		{Step{HealNetwork: true, CMD: "true"}, "both a command and heal_network"},
+		{Step{HealNetwork: true, PartitionNetwork: &NetworkPartition{}}, "both partition_network and heal_network"},
+	} {
+		err := validateSelections([]Step{c.step}, subsetPartition, config)
+		if err == nil {
+			err = validateStepTypes([]Step{c.step})
+		}
+		checkError(t, c.step, err, c.message)
+	}
+}
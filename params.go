@@ -3,8 +3,15 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+
+	yaml "gopkg.in/yaml.v2"
 )
 
 // Params holds the parameter mappings specified  by user
@@ -13,7 +20,11 @@ type Params map[string]string
 var pNamePattern = `([A-Za-z_][0-9A-Za-z_]*)`
 
 var pNameRegex = regexp.MustCompile(`^` + pNamePattern + `$`)
-var paramDecRegex = regexp.MustCompile(`{{ *` + pNamePattern + ` *}}`)
+
+// Declarations can also hold an arithmetic expression like {{ 2*Y*N + 3*Y }},
+// which starts with a name, a number or a parenthesis.  Shell syntax ($, ;,
+// braces) and go templates like {{.status.phase}} are left alone.
+var paramExprDecRegex = regexp.MustCompile(`{{( *[A-Za-z0-9_(][-A-Za-z0-9_. +*/%()<>=!&|'"]*)}}`)
 var paramRegex = regexp.MustCompile(pNamePattern + `=(.+?)`)
 
 // specific Params -> String transformation
@@ -46,27 +57,268 @@ func (params *Params) Set(input string) error {
 func replaceParams(fileData []byte, params Params) ([]byte, error) {
 	// get all unique param declarations from test file
 	matches := uniqueParamDeclarations(fileData)
-	for pDeclaration, pName := range matches {
-		// check whether the reference parameter is defined
-		if pVal, ok := params[pName]; ok {
-			// parameter found, replace all occurrences with its value
-			fileData = bytes.Replace(fileData, []byte(pDeclaration), []byte(pVal), -1)
+	for pDeclaration, pExpr := range matches {
+		var pVal string
+		if pNameRegex.MatchString(pExpr) {
+			// check whether the reference parameter is defined
+			val, ok := params[pExpr]
+			if !ok {
+				// parameter not found, fail
+				return []byte{}, fmt.Errorf("Parameter %s not specified", pExpr)
+			}
+			pVal = val
 		} else {
-			// parameter not found, fail
-			return []byte{}, fmt.Errorf("Parameter %s not specified", pName)
+			val, err := evalParamExpr(pExpr, params)
+			if err != nil {
+				return []byte{}, fmt.Errorf("Parameter expression %s: %s", pDeclaration, err)
+			}
+			pVal = val
 		}
+		// replace all occurrences with its value
+		fileData = bytes.Replace(fileData, []byte(pDeclaration), []byte(pVal), -1)
 	}
 	return fileData, nil
 }
 
-/* returns all unique parameter declarations in a test file */
+// returns all unique parameter declarations in a test file.  Matches
+// that are not expressions, like {{json .State}}, are not declarations.
 func uniqueParamDeclarations(fileData []byte) map[string]string {
 	// get all regex results
-	regexResults := paramDecRegex.FindAllSubmatch(fileData, -1)
-	// matchSet is map from '{{ <varName> }}' to '<varName>'
+	regexResults := paramExprDecRegex.FindAllSubmatch(fileData, -1)
+	// matchSet is map from '{{ <expr> }}' to '<expr>'
 	matchSet := make(map[string]string)
 	for _, result := range regexResults {
-		matchSet[string(result[0])] = string(result[1])
+		expr := strings.TrimSpace(string(result[1]))
+		if !pNameRegex.MatchString(expr) {
+			if _, err := parseExpr(expr); err != nil {
+				continue
+			}
+		}
+		matchSet[string(result[0])] = expr
 	}
 	return matchSet
 }
+
+// paramScope lets parameter expressions refer to other parameters
+type paramScope Params
+
+func (scope paramScope) variable(name string) (string, error) {
+	if value, ok := scope[name]; ok {
+		return value, nil
+	}
+	return "", fmt.Errorf("Parameter %s not specified", name)
+}
+
+func (scope paramScope) element(name string, index int) (string, error) {
+	return "", fmt.Errorf("arrays like %s are not available in parameter expressions", name)
+}
+
+func (scope paramScope) length(name string) (int, error) {
+	return 0, fmt.Errorf("arrays like %s are not available in parameter expressions", name)
+}
+
+func (scope paramScope) iteration() int {
+	return 0
+}
+
+func evalParamExpr(pExpr string, params Params) (string, error) {
+	node, err := parseExpr(pExpr)
+	if err != nil {
+		return "", err
+	}
+	return node.eval(paramScope(params))
+}
+
+const (
+	paramInt    = "int"
+	paramFloat  = "float"
+	paramString = "string"
+	paramBool   = "bool"
+)
+
+// ParamSpec declares a test parameter in the `params:` section of a
+// test file.  Declared parameters are type checked, may have a default
+// and, for numbers, an allowed range.
+//
+//	params:
+//	  N:
+//	    type: int
+//	    default: 3
+//	    min: 2
+//	    description: Number of cluster peers
+type ParamSpec struct {
+	Type        string      `yaml:"type"` /* int, float, bool or string (default) */
+	Default     interface{} `yaml:"default"`
+	Min         *float64    `yaml:"min"`
+	Max         *float64    `yaml:"max"`
+	Allowed     []string    `yaml:"allowed"`
+	Description string      `yaml:"description"`
+}
+
+// ParamSchema maps parameter names to their declaration
+type ParamSchema map[string]ParamSpec
+
+// loadParamSchema reads the `params:` section of a test file before its
+// parameters are replaced.  Declarations are swapped for a placeholder
+// so that lines like `nodes: {{N}}` don't break the YAML parser.
+func loadParamSchema(fileData []byte) (ParamSchema, error) {
+	var header struct {
+		Params ParamSchema `yaml:"params"`
+	}
+	placeholders := paramExprDecRegex.ReplaceAll(fileData, []byte("0"))
+	if err := yaml.Unmarshal(placeholders, &header); err != nil {
+		return nil, err
+	}
+	for name, spec := range header.Params {
+		if !pNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("Invalid parameter name %s", name)
+		}
+		switch spec.Type {
+		case "", paramInt, paramFloat, paramString, paramBool:
+		default:
+			return nil, fmt.Errorf("Parameter %s has unknown type %s", name, spec.Type)
+		}
+		if spec.Default != nil {
+			if err := spec.check(fmt.Sprint(spec.Default)); err != nil {
+				return nil, fmt.Errorf("Default of parameter %s: %s", name, err)
+			}
+		}
+	}
+	return header.Params, nil
+}
+
+// resolve returns params completed with the defaults of the schema,
+// failing if a value does not fit its declaration
+func (schema ParamSchema) resolve(params Params) (Params, error) {
+	resolved := make(Params)
+	for name, value := range params {
+		resolved[name] = value
+	}
+	for _, name := range schema.names() {
+		spec := schema[name]
+		value, ok := resolved[name]
+		if !ok {
+			if spec.Default == nil {
+				continue /* reported as not specified if the test uses it */
+			}
+			value = fmt.Sprint(spec.Default)
+			resolved[name] = value
+		}
+		if err := spec.check(value); err != nil {
+			return nil, fmt.Errorf("Parameter %s: %s", name, err)
+		}
+	}
+	return resolved, nil
+}
+
+func (spec ParamSpec) check(value string) error {
+	var number float64
+	var err error
+	switch spec.Type {
+	case paramInt:
+		var n int
+		n, err = strconv.Atoi(value)
+		number = float64(n)
+	case paramFloat:
+		number, err = strconv.ParseFloat(value, 64)
+	case paramBool:
+		_, err = strconv.ParseBool(value)
+	}
+	if err != nil {
+		return fmt.Errorf("%q is not a valid %s", value, spec.Type)
+	}
+	if spec.Type == paramInt || spec.Type == paramFloat {
+		if spec.Min != nil && number < *spec.Min {
+			return fmt.Errorf("%s is below the minimum of %v", value, *spec.Min)
+		}
+		if spec.Max != nil && number > *spec.Max {
+			return fmt.Errorf("%s is above the maximum of %v", value, *spec.Max)
+		}
+	}
+	if len(spec.Allowed) != 0 && !containsString(spec.Allowed, value) {
+		return fmt.Errorf("%q is not one of %v", value, spec.Allowed)
+	}
+	return nil
+}
+
+func (schema ParamSchema) names() []string {
+	names := make([]string, 0, len(schema))
+	for name := range schema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// listParams prints the declared parameters of a test file, and those
+// it uses without declaring them
+func listParams(filePath string, params Params) error {
+	fileData, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	schema, err := loadParamSchema(fileData)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTYPE\tDEFAULT\tVALUE\tCONSTRAINTS\tDESCRIPTION")
+	for _, name := range schema.names() {
+		spec := schema[name]
+		typ := spec.Type
+		if typ == "" {
+			typ = paramString
+		}
+		def := "-"
+		if spec.Default != nil {
+			def = fmt.Sprint(spec.Default)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", name, typ, def, paramValue(params, name), spec.constraints(), spec.Description)
+	}
+
+	used := make(map[string]bool)
+	for _, pExpr := range uniqueParamDeclarations(fileData) {
+		node, err := parseExpr(pExpr)
+		if err != nil {
+			continue
+		}
+		for _, name := range node.variables() {
+			used[name] = true
+		}
+	}
+	undeclared := make([]string, 0)
+	for name := range used {
+		if _, ok := schema[name]; !ok {
+			undeclared = append(undeclared, name)
+		}
+	}
+	sort.Strings(undeclared)
+	for _, name := range undeclared {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", name, "-", "-", paramValue(params, name), "-", "(not declared)")
+	}
+	return w.Flush()
+}
+
+func paramValue(params Params, name string) string {
+	if value, ok := params[name]; ok {
+		return value
+	}
+	return "-"
+}
+
+func (spec ParamSpec) constraints() string {
+	constraints := make([]string, 0)
+	if spec.Min != nil {
+		constraints = append(constraints, fmt.Sprintf(">= %v", *spec.Min))
+	}
+	if spec.Max != nil {
+		constraints = append(constraints, fmt.Sprintf("<= %v", *spec.Max))
+	}
+	if len(spec.Allowed) != 0 {
+		constraints = append(constraints, "one of "+strings.Join(spec.Allowed, ", "))
+	}
+	if len(constraints) == 0 {
+		return "-"
+	}
+	return strings.Join(constraints, ", ")
+}
@@ -146,3 +146,95 @@ func TestUndeclaredParams(t *testing.T) {
 		t.Fatal(fmt.Sprintf("Failed with different error than intended: %s", err))
 	}
 }
+
+// test that arithmetic expressions are evaluated with parameter values
+func TestExpressionReplacement(t *testing.T) {
+	template := []byte("successes: {{ 2*Y*N + 3*Y }}\nhalf: {{N / 2 + 1}}\nawk: awk '{{print $1}}'\n")
+	processed, err := replaceParams(template, Params{"N": "5", "Y": "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := "successes: 39\nhalf: 3\nawk: awk '{{print $1}}'\n"
+	if string(processed) != target {
+		t.Fatalf("Unexpected replacement:\n%s", processed)
+	}
+
+	_, err = replaceParams([]byte("nodes: {{ N + 1 }}"), Params{})
+	if err == nil {
+		t.Fatal("Expression with a missing parameter should fail")
+	}
+
+	/* Go templates of kubectl and docker are not parameters */
+	templates := []byte("cmd: kubectl get pod -o go-template='{{.status.phase}}' && docker inspect -f '{{ .State }}' x && " +
+		"docker inspect -f '{{json .State}}' x")
+	processed, err = replaceParams(templates, Params{})
+	if err != nil || string(processed) != string(templates) {
+		t.Fatalf("Expected templates to be left alone, got %s (%v)", processed, err)
+	}
+}
+
+// test that declared parameters get defaults and are validated
+func TestParamSchema(t *testing.T) {
+	fileData := []byte(`name: Schema {{NAME}}
+params:
+  N:
+    type: int
+    default: 3
+    min: 2
+    max: 10
+  MODE:
+    allowed: [fast, slow]
+  NAME:
+    description: Shown in the test name
+config:
+  nodes: {{N}}
+`)
+	schema, err := loadParamSchema(fileData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schema) != 3 || schema["NAME"].Description != "Shown in the test name" {
+		t.Fatalf("Unexpected schema: %+v", schema)
+	}
+
+	params, err := schema.resolve(Params{"NAME": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params["N"] != "3" {
+		t.Fatalf("Default not applied: %v", params)
+	}
+	if _, ok := params["MODE"]; ok {
+		t.Fatal("Parameter without default should stay unset")
+	}
+
+	for _, bad := range []Params{{"N": "1"}, {"N": "11"}, {"N": "two"}, {"MODE": "medium"}} {
+		if _, err := schema.resolve(bad); err == nil {
+			t.Errorf("Expected %v to fail validation", bad)
+		}
+	}
+
+	if _, err := loadParamSchema([]byte("params:\n  N:\n    type: int\n    default: many\n")); err == nil {
+		t.Fatal("Default of the wrong type should fail")
+	}
+	if _, err := loadParamSchema([]byte("params:\n  N:\n    type: integer\n")); err == nil {
+		t.Fatal("Unknown type should fail")
+	}
+}
+
+// test that tests using the schema load with defaults and expressions
+func TestSchemaTestFile(t *testing.T) {
+	config := newTestConfig()
+	config.addParams(Params{"N": "4", "Y": "5"})
+	test, err := loadTest("ipfs-cluster/tests/0008-block_majority.yml", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if test.Config.Nodes != 4 || test.Config.Expected.Successes != 2*5*4+3*5 {
+		t.Fatalf("Parameters not applied: %+v", test.Config)
+	}
+	config.addParams(Params{"N": "1"})
+	if _, err := loadTest("ipfs-cluster/tests/0008-block_majority.yml", config); err == nil {
+		t.Fatal("N below its minimum should fail")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+/* How long to wait for the deployment to replace pods */
+var podReplaceTimeout = 5 * time.Minute
+
+// live tells whether the pod runs and is not being deleted
+func (pod Pod) live() bool {
+	return pod.Status.Phase == "Running" && pod.Metadata.DeletionTimestamp == nil
+}
+
+// livePods drops the pods that are not running or are being deleted, so
+// that no node is given a pod it can't use
+func livePods(pods *GetPodsOutput) *GetPodsOutput {
+	live := &GetPodsOutput{Items: make([]Pod, 0, len(pods.Items))}
+	for _, pod := range pods.Items {
+		if pod.live() {
+			live.Items = append(live.Items, pod)
+		}
+	}
+	return live
+}
+
+// podReplacement is a node whose pod was replaced by another one
+type podReplacement struct {
+	node    int
+	oldName string
+	newName string
+}
+
+// remapPods keeps the nodes of the pods that are still live and gives the
+// nodes of the others to the new pods, in the order of their names
+func remapPods(old *GetPodsOutput, fresh *GetPodsOutput) (*GetPodsOutput, []podReplacement) {
+	live := make(map[string]Pod)
+	for _, pod := range fresh.Items {
+		if pod.live() {
+			live[pod.Metadata.Name] = pod
+		}
+	}
+	remapped := &GetPodsOutput{Items: make([]Pod, len(old.Items))}
+	vacant := make([]int, 0)
+	for i, pod := range old.Items {
+		if current, ok := live[pod.Metadata.Name]; ok {
+			remapped.Items[i] = current
+			delete(live, pod.Metadata.Name)
+		} else {
+			vacant = append(vacant, i)
+		}
+	}
+	names := make([]string, 0, len(live))
+	for name := range live {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	replacements := make([]podReplacement, 0)
+	for n, i := range vacant {
+		replacement := podReplacement{node: i + 1, oldName: old.Items[i].Metadata.Name}
+		if n < len(names) {
+			remapped.Items[i] = live[names[n]]
+			replacement.newName = names[n]
+		} else {
+			remapped.Items[i] = old.Items[i]
+		}
+		replacements = append(replacements, replacement)
+	}
+	/* New pods left over are kept after the others */
+	for n := len(vacant); n < len(names); n++ {
+		remapped.Items = append(remapped.Items, live[names[n]])
+	}
+	return remapped, replacements
+}
+
+// refreshPods waits until every node of the test has a live pod, then
+// updates pods in place so that these nodes refer to live pods, and returns
+// the nodes whose pod was replaced.  Pods beyond the nodes of the test are
+// not waited for.
+func refreshPods(cfg *Config, pods *GetPodsOutput) ([]podReplacement, error) {
+	deadline := time.Now().Add(podReplaceTimeout)
+	for {
+		fresh, err := getPods(cfg)
+		if err != nil {
+			return nil, err
+		}
+		remapped, replacements := remapPods(pods, fresh)
+		complete := true
+		found := make([]podReplacement, 0, len(replacements))
+		for _, replacement := range replacements {
+			if replacement.newName != "" {
+				found = append(found, replacement)
+			} else if replacement.node <= cfg.Nodes {
+				complete = false
+			}
+		}
+		if complete {
+			*pods = *remapped
+			for _, replacement := range found {
+				color.Yellow("### Node %d now runs on pod %s instead of %s", replacement.node, replacement.newName, replacement.oldName)
+			}
+			return found, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("pods were not replaced after %s", podReplaceTimeout)
+		}
+		time.Sleep(3 * time.Second)
+	}
+}
+
+// vanishedPods returns the nodes among the first nodes whose pod is no
+// longer live in fresh
+func vanishedPods(pods *GetPodsOutput, fresh *GetPodsOutput, nodes int) []int {
+	live := make(map[string]bool)
+	for _, pod := range fresh.Items {
+		if pod.live() {
+			live[pod.Metadata.Name] = true
+		}
+	}
+	vanished := make([]int, 0)
+	for i, pod := range pods.Items {
+		if i < nodes && !live[pod.Metadata.Name] {
+			vanished = append(vanished, i+1)
+		}
+	}
+	return vanished
+}
+
+// checkPods makes sure that every node of the test still has a live pod
+// before a step runs, waiting for the deployment to replace the pods that
+// vanished
+func checkPods(config Config, pods *GetPodsOutput, summary *Summary, phase string, step string) {
+	fresh, err := getPods(&config)
+	if err != nil {
+		color.Yellow("### Could not check the pods: %s", err)
+		return
+	}
+	vanished := vanishedPods(pods, fresh, config.Nodes)
+	if len(vanished) == 0 {
+		return
+	}
+	color.Yellow("### The pods of nodes %v are gone, waiting for their replacements", vanished)
+	replacements, err := refreshPods(&config, pods)
+	if err != nil {
+		color.Red("Failed to replace the pods of nodes %v: %s", vanished, err)
+		summary.Failures++
+		return
+	}
+	recordReplacements(summary, phase, step, replacements)
+}
+
+// recordReplacements adds an event for every replaced pod
+func recordReplacements(summary *Summary, phase string, step string, replacements []podReplacement) {
+	for _, replacement := range replacements {
+		if replacement.newName == "" {
+			continue
+		}
+		summary.addEvent(phase, step, "pod_replaced", []int{replacement.node},
+			replacement.oldName+" -> "+replacement.newName)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func podList(names ...string) *GetPodsOutput {
+	pods := &GetPodsOutput{}
+	for _, name := range names {
+		var pod Pod
+		pod.Metadata.Name = name
+		pod.Status.Phase = "Running"
+		pods.Items = append(pods.Items, pod)
+	}
+	return pods
+}
+
+func podNames(pods *GetPodsOutput) []string {
+	names := make([]string, 0, len(pods.Items))
+	for _, pod := range pods.Items {
+		names = append(names, pod.Metadata.Name)
+	}
+	return names
+}
+
+// test that surviving pods keep their node and new pods fill the gaps
+func TestRemapPods(t *testing.T) {
+	old := podList("a", "b", "c", "d")
+	fresh := podList("a", "c", "d", "b", "y", "x", "z")
+	deleted := "now"
+	fresh.Items[3].Metadata.DeletionTimestamp = &deleted
+	fresh.Items[2].Status.Phase = "Failed"
+
+	remapped, replacements := remapPods(old, fresh)
+	if names := podNames(remapped); !reflect.DeepEqual(names, []string{"a", "x", "c", "y", "z"}) {
+		t.Fatalf("Unexpected pods: %v", names)
+	}
+	expected := []podReplacement{{2, "b", "x"}, {4, "d", "y"}}
+	if !reflect.DeepEqual(replacements, expected) {
+		t.Fatalf("Unexpected replacements: %v", replacements)
+	}
+
+	/* Without enough new pods, nodes keep their old pod */
+	remapped, replacements = remapPods(old, podList("a", "b", "c"))
+	if names := podNames(remapped); !reflect.DeepEqual(names, []string{"a", "b", "c", "d"}) || replacements[0].newName != "" {
+		t.Fatalf("Unexpected pods: %v %v", names, replacements)
+	}
+}
+
+func TestVanishedPods(t *testing.T) {
+	pods := podList("a", "b", "c")
+	fresh := podList("a", "c", "d")
+	fresh.Items[1].Status.Phase = "Pending"
+	if vanished := vanishedPods(pods, fresh, 3); !reflect.DeepEqual(vanished, []int{2, 3}) {
+		t.Fatalf("Unexpected vanished nodes: %v", vanished)
+	}
+	if vanished := vanishedPods(pods, pods, 3); len(vanished) != 0 {
+		t.Fatalf("Unexpected vanished nodes: %v", vanished)
+	}
+	/* Pods beyond the nodes of the test are not checked */
+	if vanished := vanishedPods(pods, fresh, 1); len(vanished) != 0 {
+		t.Fatalf("Unexpected vanished nodes: %v", vanished)
+	}
+}
+
+func TestLivePods(t *testing.T) {
+	pods := podList("a", "b", "c")
+	pods.Items[0].Status.Phase = "Pending"
+	deleted := "2020-01-01T00:00:00Z"
+	pods.Items[2].Metadata.DeletionTimestamp = &deleted
+	if names := podNames(livePods(pods)); !reflect.DeepEqual(names, []string{"b"}) {
+		t.Fatalf("Unexpected live pods: %v", names)
+	}
+}
+
+func TestRecordReplacements(t *testing.T) {
+	var summary Summary
+	recordReplacements(&summary, "steps", "kill", []podReplacement{{1, "a", "x"}, {2, "b", ""}})
+	if len(summary.Events) != 1 || summary.Events[0].Kind != "pod_replaced" ||
+		summary.Events[0].Detail != "a -> x" || !reflect.DeepEqual(summary.Events[0].Nodes, []int{1}) {
+		t.Fatalf("Unexpected events: %+v", summary.Events)
+	}
+}
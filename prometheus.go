@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+/* Seconds between the samples of a query over the test window by default */
+const defaultQueryStep = 15
+
+/* How long a query may take when the step has no timeout */
+const defaultQueryTimeout = 30
+
+// rangePlaceholder is replaced in queries with the duration of the test
+// window, e.g. max_over_time(up[$RANGE])
+const rangePlaceholder = "$RANGE"
+
+// PrometheusQuery asserts on the values of a PromQL query evaluated over
+// the time window of the test, from its start to the step.  Every value of
+// every series must be within the bounds.
+type PrometheusQuery struct {
+	Query string   `yaml:"query"`
+	Below *float64 `yaml:"below"` /* Values must be lower */
+	Above *float64 `yaml:"above"` /* Values must be higher */
+	Step  int      `yaml:"step"`  /* Seconds between samples, default 15 */
+}
+
+func (pq *PrometheusQuery) validate(config Config) error {
+	switch {
+	case config.Prometheus == "":
+		return errors.New("Prometheus query without prometheus URL in config")
+	case pq.Query == "":
+		return errors.New("Prometheus query is empty")
+	case pq.Below == nil && pq.Above == nil:
+		return errors.New("Prometheus query needs below or above")
+	case pq.Below != nil && pq.Above != nil && *pq.Above >= *pq.Below:
+		return errors.New("Prometheus query bounds leave no value")
+	case pq.Step < 0:
+		return errors.New("Prometheus query step may not be negative")
+	}
+	return nil
+}
+
+// String describes the assertion for logs and plans
+func (pq *PrometheusQuery) String() string {
+	bounds := make([]string, 0, 2)
+	if pq.Above != nil {
+		bounds = append(bounds, fmt.Sprintf("above %g", *pq.Above))
+	}
+	if pq.Below != nil {
+		bounds = append(bounds, fmt.Sprintf("below %g", *pq.Below))
+	}
+	return pq.Query + " " + strings.Join(bounds, " and ")
+}
+
+// check returns an error for the first value out of bounds
+func (pq *PrometheusQuery) check(series []promSeries) error {
+	if len(series) == 0 {
+		return errors.New("query returned no data")
+	}
+	for _, s := range series {
+		for _, sample := range s.Values {
+			switch {
+			case math.IsNaN(sample.value):
+				return fmt.Errorf("%s is NaN at %s", s.Metric, sample.time.Format(time.RFC3339))
+			case pq.Below != nil && sample.value >= *pq.Below:
+				return fmt.Errorf("%s is %g at %s, not below %g", s.Metric, sample.value, sample.time.Format(time.RFC3339), *pq.Below)
+			case pq.Above != nil && sample.value <= *pq.Above:
+				return fmt.Errorf("%s is %g at %s, not above %g", s.Metric, sample.value, sample.time.Format(time.RFC3339), *pq.Above)
+			}
+		}
+	}
+	return nil
+}
+
+type promSample struct {
+	time  time.Time
+	value float64
+}
+
+// UnmarshalJSON decodes a [unix time, "value"] pair
+func (sample *promSample) UnmarshalJSON(data []byte) error {
+	var pair []interface{}
+	if err := json.Unmarshal(data, &pair); err != nil {
+		return err
+	}
+	if len(pair) != 2 {
+		return fmt.Errorf("invalid sample %s", data)
+	}
+	seconds, ok := pair[0].(float64)
+	text, isText := pair[1].(string)
+	if !ok || !isText {
+		return fmt.Errorf("invalid sample %s", data)
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return err
+	}
+	sample.time = time.Unix(0, int64(seconds*float64(time.Second)))
+	sample.value = value
+	return nil
+}
+
+type promSeries struct {
+	Metric promMetric   `json:"metric"`
+	Values []promSample `json:"values"`
+}
+
+type promMetric map[string]string
+
+// String prints the metric as PromQL would select it
+func (metric promMetric) String() string {
+	name := metric["__name__"]
+	labels := make([]string, 0, len(metric))
+	for _, key := range sortedKeys(metric) {
+		if key != "__name__" {
+			labels = append(labels, fmt.Sprintf("%s=%q", key, metric[key]))
+		}
+	}
+	return name + "{" + strings.Join(labels, ",") + "}"
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+/* Response of the Prometheus HTTP API */
+type promResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		ResultType string       `json:"resultType"`
+		Result     []promSeries `json:"result"`
+	} `json:"data"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+}
+
+// queryRange evaluates a query between start and end with the query_range
+// endpoint of the Prometheus at base
+func queryRange(base string, query string, start time.Time, end time.Time, step int, timeout int) ([]promSeries, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", strconv.FormatInt(start.Unix(), 10))
+	params.Set("end", strconv.FormatInt(end.Unix(), 10))
+	params.Set("step", strconv.Itoa(step))
+	client := http.Client{Timeout: time.Duration(timeout) * time.Second}
+	resp, err := client.Get(strings.TrimSuffix(base, "/") + "/api/v1/query_range?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var result promResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("%s: %s", resp.Status, err)
+	}
+	if result.Status != "success" {
+		return nil, fmt.Errorf("%s: %s", result.ErrorType, result.Error)
+	}
+	if result.Data.ResultType != "matrix" {
+		return nil, fmt.Errorf("unexpected result type %s", result.Data.ResultType)
+	}
+	return result.Data.Result, nil
+}
+
+// expandRange replaces the range placeholder of a query with the length
+// of the window, in whole seconds and at least one step
+func expandRange(query string, window time.Duration, step int) string {
+	seconds := int(window.Seconds())
+	if seconds < step {
+		seconds = step
+	}
+	return strings.Replace(query, rangePlaceholder, strconv.Itoa(seconds)+"s", -1)
+}
+
+// handlePrometheus runs the query of the step over the test window and
+// counts a success if every value is within bounds
+func handlePrometheus(step *Step, summary *Summary, config Config) {
+	pq := step.Prometheus
+	interval := pq.Step
+	if interval == 0 {
+		interval = defaultQueryStep
+	}
+	timeout := step.Timeout
+	if timeout == 0 {
+		timeout = defaultQueryTimeout
+	}
+	end := time.Now()
+	start := summary.Start
+	if start.IsZero() || !start.Before(end) {
+		start = end
+	}
+	query := expandRange(pq.Query, end.Sub(start), interval)
+	color.Cyan("### Querying Prometheus: %s", pq)
+	series, err := queryRange(config.Prometheus, query, start, end, interval, timeout)
+	if err == nil {
+		err = pq.check(series)
+	}
+	if err != nil {
+		color.Red("Assertion failed: %s", err)
+		summary.Failures++
+		return
+	}
+	color.Green("Assertion Passed")
+	summary.Successes++
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubPrometheus answers query_range requests with the matrix of values
+// and records the last query it got
+func stubPrometheus(t *testing.T, values map[string][]string, lastQuery *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query_range" {
+			http.NotFound(w, r)
+			return
+		}
+		query := r.URL.Query()
+		*lastQuery = query.Get("query")
+		if strings.Contains(*lastQuery, "syntax error") {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"status":"error","errorType":"bad_data","error":"parse error"}`)
+			return
+		}
+		for _, param := range []string{"start", "end", "step"} {
+			if query.Get(param) == "" {
+				t.Errorf("Missing %s in query", param)
+			}
+		}
+		series := make([]string, 0)
+		for pod, samples := range values {
+			pairs := make([]string, 0)
+			for i, value := range samples {
+				pairs = append(pairs, fmt.Sprintf(`[%d.5,"%s"]`, 1500000000+15*i, value))
+			}
+			series = append(series, fmt.Sprintf(`{"metric":{"__name__":"memory","pod":"%s"},"values":[%s]}`, pod, strings.Join(pairs, ",")))
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[%s]}}`, strings.Join(series, ","))
+	}))
+}
+
+func TestHandlePrometheus(t *testing.T) {
+	var lastQuery string
+	server := stubPrometheus(t, map[string][]string{
+		"a": {"100", "300", "200"},
+		"b": {"50", "60"},
+	}, &lastQuery)
+	defer server.Close()
+	config := Config{Prometheus: server.URL + "/"}
+
+	bound := func(value float64) *float64 { return &value }
+	for _, c := range []struct {
+		query     PrometheusQuery
+		successes int
+	}{
+		{PrometheusQuery{Query: "memory", Below: bound(301)}, 1},
+		{PrometheusQuery{Query: "memory", Below: bound(300)}, 0},
+		{PrometheusQuery{Query: "memory", Above: bound(40), Below: bound(400)}, 1},
+		{PrometheusQuery{Query: "memory", Above: bound(50)}, 0},
+		{PrometheusQuery{Query: "syntax error", Below: bound(1)}, 0},
+	} {
+		summary := Summary{Start: time.Now().Add(-time.Minute)}
+		step := Step{Name: "query", Prometheus: &c.query}
+		handlePrometheus(&step, &summary, config)
+		if summary.Successes != c.successes || summary.Successes+summary.Failures != 1 {
+			t.Errorf("%s: expected %d successes, got %+v", c.query.String(), c.successes, summary)
+		}
+	}
+
+	query := PrometheusQuery{Query: "max_over_time(memory[$RANGE])", Below: bound(1000)}
+	summary := Summary{Start: time.Now().Add(-90 * time.Second)}
+	handlePrometheus(&Step{Prometheus: &query}, &summary, config)
+	if lastQuery != "max_over_time(memory[90s])" {
+		t.Errorf("Unexpected query %s", lastQuery)
+	}
+}
+
+func TestPrometheusNoData(t *testing.T) {
+	var lastQuery string
+	server := stubPrometheus(t, map[string][]string{}, &lastQuery)
+	defer server.Close()
+	below := 1.0
+	err := (&PrometheusQuery{Query: "absent", Below: &below}).check(nil)
+	if err == nil || !strings.Contains(err.Error(), "no data") {
+		t.Fatalf("Expected no data, got %v", err)
+	}
+	series, err := queryRange(server.URL, "absent", time.Now(), time.Now(), 15, 5)
+	if err != nil || len(series) != 0 {
+		t.Fatalf("Unexpected result %v, %v", series, err)
+	}
+}
+
+func TestValidatePrometheus(t *testing.T) {
+	one, two := 1.0, 2.0
+	config := Config{Prometheus: "http://prometheus:9090"}
+	for _, c := range []struct {
+		query   PrometheusQuery
+		config  Config
+		message string
+	}{
+		{PrometheusQuery{Query: "up", Above: &one}, config, ""},
+		{PrometheusQuery{Query: "up", Above: &one, Below: &two}, config, ""},
+		{PrometheusQuery{Query: "up", Above: &one}, Config{}, "without prometheus URL"},
+		{PrometheusQuery{Above: &one}, config, "empty"},
+		{PrometheusQuery{Query: "up"}, config, "needs below or above"},
+		{PrometheusQuery{Query: "up", Above: &two, Below: &one}, config, "leave no value"},
+		{PrometheusQuery{Query: "up", Above: &one, Step: -1}, config, "step may not be negative"},
+	} {
+		err := c.query.validate(c.config)
+		checkError(t, c.query.String(), err, c.message)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+/* How long to wait for the nodes to become healthy by default */
+const defaultReadinessTimeout = 120
+
+/* Time between rounds of checks of the nodes that are not healthy yet */
+const readinessRetry = 2 * time.Second
+
+/* Counts the peers listed by ipfs-cluster-ctl, whose addresses are indented */
+const defaultPeersCMD = "ipfs-cluster-ctl peers ls | grep -c '^[^ ]'"
+
+// Readiness is a health check that must pass on every node of the test
+// before its first step runs.  Every check given must pass.
+type Readiness struct {
+	CMD      string `yaml:"cmd"`       /* Command that must succeed, e.g. ipfs id */
+	Peers    int    `yaml:"peers"`     /* Number of peers each node must see */
+	PeersCMD string `yaml:"peers_cmd"` /* Prints the number of peers, default counts ipfs-cluster-ctl peers ls */
+	URL      string `yaml:"url"`       /* HTTP URL, fetched from inside the pod, that must answer */
+	Timeout  int    `yaml:"timeout"`   /* Seconds, default 120 */
+}
+
+// NodeHealth is a node that never passed the readiness checks
+type NodeHealth struct {
+	Repetition int    `json:"repetition"`
+	Node       int    `json:"node"`
+	Pod        string `json:"pod"`
+	Reason     string `json:"reason"`
+}
+
+func (readiness *Readiness) validate() error {
+	switch {
+	case readiness.CMD == "" && readiness.Peers == 0 && readiness.URL == "":
+		return errors.New("Readiness needs a cmd, peers or url")
+	case readiness.Peers < 0:
+		return errors.New("Readiness peers may not be negative")
+	case readiness.PeersCMD != "" && readiness.Peers == 0:
+		return errors.New("Readiness peers_cmd without peers")
+	case readiness.URL != "" && !strings.HasPrefix(readiness.URL, "http://") && !strings.HasPrefix(readiness.URL, "https://"):
+		return fmt.Errorf("Readiness url %s is not an HTTP URL", readiness.URL)
+	case strings.ContainsAny(readiness.URL, "'\n"):
+		return errors.New("Readiness url may not contain quotes")
+	case readiness.Timeout < 0:
+		return errors.New("Readiness timeout may not be negative")
+	}
+	return nil
+}
+
+func (readiness *Readiness) timeout() time.Duration {
+	if readiness.Timeout == 0 {
+		return defaultReadinessTimeout * time.Second
+	}
+	return time.Duration(readiness.Timeout) * time.Second
+}
+
+// String describes the checks for the plan
+func (readiness *Readiness) String() string {
+	checks := make([]string, 0)
+	if readiness.CMD != "" {
+		checks = append(checks, "cmd "+readiness.CMD)
+	}
+	if readiness.Peers != 0 {
+		checks = append(checks, fmt.Sprintf("%d peers", readiness.Peers))
+	}
+	if readiness.URL != "" {
+		checks = append(checks, "url "+readiness.URL)
+	}
+	return strings.Join(checks, ", ")
+}
+
+// command returns the command that fails with the reason on its error
+// output while the node is not ready
+func (readiness *Readiness) command() string {
+	lines := make([]string, 0)
+	if readiness.CMD != "" {
+		lines = append(lines, fmt.Sprintf("(%s) >/dev/null 2>&1 || { echo 'command failed' >&2; exit 1; }", readiness.CMD))
+	}
+	if readiness.Peers != 0 {
+		peersCMD := readiness.PeersCMD
+		if peersCMD == "" {
+			peersCMD = defaultPeersCMD
+		}
+		lines = append(lines,
+			fmt.Sprintf("peers=$( (%s) 2>/dev/null | tail -n 1)", peersCMD),
+			fmt.Sprintf("[ \"${peers:-0}\" -ge %[1]d ] 2>/dev/null || { echo \"sees ${peers:-no} of %[1]d peers\" >&2; exit 1; }", readiness.Peers))
+	}
+	if readiness.URL != "" {
+		lines = append(lines, fmt.Sprintf("(wget -q -O /dev/null '%[1]s' || curl -fs -o /dev/null '%[1]s') >/dev/null 2>&1 || "+
+			"{ echo '%[1]s did not answer' >&2; exit 1; }", readiness.URL))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkReadiness runs the readiness command on the pods of nodes in
+// parallel and returns the reason of every node that is not ready
+func checkReadiness(pods GetPodsOutput, nodes []int, command string, timeout int) map[int]string {
+	type nodeResult struct {
+		node   int
+		reason string
+	}
+	results := make(chan nodeResult)
+	for _, node := range nodes {
+		go func(node int) {
+			timedOut, err := runInPodWithInput(pods.Items[node-1].Metadata.Name, command, nil, timeout)
+			switch {
+			case timedOut:
+				results <- nodeResult{node, fmt.Sprintf("check timed out after %d seconds", timeout)}
+			case err != nil:
+				results <- nodeResult{node, err.Error()}
+			default:
+				results <- nodeResult{node, ""}
+			}
+		}(node)
+	}
+	unready := make(map[int]string)
+	for range nodes {
+		result := <-results
+		if result.reason != "" {
+			unready[result.node] = result.reason
+		}
+	}
+	return unready
+}
+
+// waitReady waits until every node of the test passes the readiness
+// checks, and returns the nodes that did not before the timeout
+func waitReady(readiness *Readiness, pods GetPodsOutput, config Config, repetition int) []NodeHealth {
+	color.Cyan("## Waiting for %d nodes to become healthy", config.Nodes)
+	command := readiness.command()
+	deadline := time.Now().Add(readiness.timeout())
+	nodes := makeRange(1, config.Nodes)
+	for {
+		unready := checkReadiness(pods, nodes, command, checkTimeout(deadline))
+		if len(unready) == 0 {
+			color.Green("## All nodes are healthy")
+			return nil
+		}
+		nodes = make([]int, 0, len(unready))
+		for node := range unready {
+			nodes = append(nodes, node)
+		}
+		sort.Ints(nodes)
+		/* Give up rather than start a round after the deadline */
+		if !time.Now().Add(readinessRetry).Before(deadline) {
+			unhealthy := make([]NodeHealth, 0, len(nodes))
+			for _, node := range nodes {
+				health := NodeHealth{repetition, node, pods.Items[node-1].Metadata.Name, unready[node]}
+				color.Red("## Node %d (%s) never became healthy: %s", node, health.Pod, health.Reason)
+				unhealthy = append(unhealthy, health)
+			}
+			return unhealthy
+		}
+		debug(fmt.Sprintf("Nodes %v are not healthy yet", nodes))
+		time.Sleep(readinessRetry)
+	}
+}
+
+// checkTimeout is the timeout in seconds of a round of checks.  A single
+// check may not take longer than the rest of the wait, but always has a
+// timeout, since 0 would wait forever on a hung command.
+func checkTimeout(deadline time.Time) int {
+	timeout := int(math.Ceil(time.Until(deadline).Seconds()))
+	switch {
+	case timeout < 1:
+		return 1
+	case timeout > 30:
+		return 30
+	}
+	return timeout
+}
+
+func printUnhealthy(unhealthy []NodeHealth) {
+	if len(unhealthy) == 0 {
+		return
+	}
+	fmt.Println("== Nodes that never became healthy:")
+	for _, health := range unhealthy {
+		fmt.Printf("==   repetition %d, node %d (%s): %s\n", health.Repetition, health.Node, health.Pod, health.Reason)
+	}
+}
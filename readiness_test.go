@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateReadiness(t *testing.T) {
+	for _, c := range []struct {
+		readiness Readiness
+		message   string
+	}{
+		{Readiness{CMD: "ipfs id"}, ""},
+		{Readiness{Peers: 3, Timeout: 300}, ""},
+		{Readiness{URL: "http://localhost:9094/id"}, ""},
+		{Readiness{}, "needs a cmd, peers or url"},
+		{Readiness{Peers: -1}, "may not be negative"},
+		{Readiness{CMD: "ipfs id", PeersCMD: "echo 3"}, "peers_cmd without peers"},
+		{Readiness{URL: "localhost:5001"}, "not an HTTP URL"},
+		{Readiness{URL: "http://localhost/'; rm -rf /"}, "quotes"},
+		{Readiness{CMD: "ipfs id", Timeout: -5}, "timeout may not be negative"},
+	} {
+		err := c.readiness.validate()
+		checkError(t, c.readiness, err, c.message)
+	}
+}
+
+// test the readiness command with a local shell instead of a pod
+func TestReadinessCommand(t *testing.T) {
+	run := func(readiness Readiness) string {
+		out, err := exec.Command("bash", "-c", readiness.command()+" 2>&1").CombinedOutput()
+		if err == nil {
+			return ""
+		}
+		return strings.TrimSpace(string(out))
+	}
+	for _, c := range []struct {
+		readiness Readiness
+		reason    string
+	}{
+		{Readiness{CMD: "true", Peers: 3, PeersCMD: "echo 4"}, ""},
+		{Readiness{CMD: "false", Peers: 3, PeersCMD: "echo 4"}, "command failed"},
+		{Readiness{Peers: 3, PeersCMD: "echo 2"}, "sees 2 of 3 peers"},
+		{Readiness{Peers: 3, PeersCMD: "exit 1"}, "sees no of 3 peers"},
+		{Readiness{Peers: 3, PeersCMD: "echo not a number"}, "sees not a number of 3 peers"},
+	} {
+		if reason := run(c.readiness); reason != c.reason {
+			t.Errorf("%+v: expected %q, got %q", c.readiness, c.reason, reason)
+		}
+	}
+	if timeout := (&Readiness{}).timeout().Seconds(); timeout != defaultReadinessTimeout {
+		t.Errorf("Unexpected default timeout %g", timeout)
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	now := time.Now()
+	for _, c := range []struct {
+		deadline time.Time
+		timeout  int
+	}{
+		{now.Add(-2 * time.Second), 1},
+		{now.Add(500 * time.Millisecond), 1},
+		{now.Add(10*time.Second + 500*time.Millisecond), 11},
+		{now.Add(time.Hour), 30},
+	} {
+		if timeout := checkTimeout(c.deadline); timeout != c.timeout {
+			t.Errorf("Deadline in %s: expected a timeout of %d, got %d", c.deadline.Sub(now), c.timeout, timeout)
+		}
+	}
+}
+
+func TestUnhealthyFailsTest(t *testing.T) {
+	summary := Summary{Unhealthy: []NodeHealth{{1, 2, "pod-b", "command failed"}}}
+	if evaluateOutcome(summary, Expected{}) != 1 {
+		t.Fatal("Expected unhealthy nodes to fail the test")
+	}
+	if evaluateOutcome(Summary{}, Expected{}) != 0 {
+		t.Fatal("Expected an empty summary to meet empty expectations")
+	}
+}
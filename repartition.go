@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// PartitionRecord is a subset partition that was active during a test
+type PartitionRecord struct {
+	Repetition int           `json:"repetition"` /* 0 for the finally phase */
+	Phase      string        `json:"phase"`
+	Step       string        `json:"step,omitempty"` /* Empty if made before the repetition */
+	Subsets    map[int][]int `json:"subsets"`
+}
+
+func (record PartitionRecord) String() string {
+	subsets := make([]int, 0, len(record.Subsets))
+	for subset := range record.Subsets {
+		subsets = append(subsets, subset)
+	}
+	sort.Ints(subsets)
+	parts := make([]string, 0, len(subsets))
+	for _, subset := range subsets {
+		parts = append(parts, fmt.Sprintf("%d: %v", subset, record.Subsets[subset]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// partitionSpec returns the partition a repartition step makes: its own
+// if it gives a partition_type, the one in the header otherwise
+func (step Step) partitionSpec(config Config) *SubsetPartition {
+	if step.Repartition.PartitionType != "" {
+		return step.Repartition
+	}
+	return config.SubsetPartition
+}
+
+// repartition replaces the subsets of subsetPartition in place, so that
+// the steps after it select from the new subsets
+func repartition(subsetPartition map[int][]int, config Config, spec *SubsetPartition) error {
+	config.SubsetPartition = spec
+	subsets, err := partition(config)
+	if err != nil {
+		return err
+	}
+	for subset := range subsetPartition {
+		delete(subsetPartition, subset)
+	}
+	for subset, nodes := range subsets {
+		subsetPartition[subset] = nodes
+	}
+	return nil
+}
+
+func copyPartition(subsetPartition map[int][]int) map[int][]int {
+	copied := make(map[int][]int, len(subsetPartition))
+	for subset, nodes := range subsetPartition {
+		copied[subset] = append([]int(nil), nodes...)
+	}
+	return copied
+}
+
+// handleRepartition partitions the nodes again for the steps after step
+// in its phase and records the new partition in the summary
+func handleRepartition(step *Step, summary *Summary, config Config, subsetPartition map[int][]int, phase string) {
+	if err := repartition(subsetPartition, config, step.partitionSpec(config)); err != nil {
+		color.Red("### Failed to repartition nodes: %s", err)
+		summary.Failures++
+		return
+	}
+	record := PartitionRecord{
+		Repetition: repetitionOf(summary, phase),
+		Phase:      phase,
+		Step:       step.Name,
+		Subsets:    copyPartition(subsetPartition),
+	}
+	color.Cyan("### Repartitioned nodes: %s", record)
+	summary.Partitions = append(summary.Partitions, record)
+}
+
+// staticPartition makes the partition of spec without drawing random
+// numbers.  Subsets have the same sizes as those of a random partition,
+// which is all validation needs.
+func staticPartition(config Config, spec *SubsetPartition) (map[int][]int, error) {
+	static := *spec
+	static.Order = sequential
+	config.SubsetPartition = &static
+	return partition(config)
+}
+
+// validateRepartitions checks that repartition steps make a valid
+// partition
+func validateRepartitions(steps []Step, config Config) error {
+	for idx, step := range steps {
+		if step.Repartition == nil {
+			continue
+		}
+		if step.Repartition.EachRepetition {
+			return validateError(idx, "each_repetition only applies to the partition in the header")
+		}
+		spec := step.partitionSpec(config)
+		if spec == nil {
+			return validateError(idx, "Repartition without partition_type and no partition in header")
+		}
+		if spec.Order != sequential && spec.Order != random {
+			return validateError(idx, "Partition has invalid ordering")
+		}
+		if _, err := staticPartition(config, spec); err != nil {
+			return validateError(idx, err.Error())
+		}
+	}
+	return nil
+}
+
+func printPartitions(records []PartitionRecord) {
+	if len(records) == 0 {
+		return
+	}
+	fmt.Println("== Partitions:")
+	for _, record := range records {
+		var when string
+		switch {
+		case record.Step == "":
+			when = fmt.Sprintf("repetition %d", record.Repetition)
+		case record.Phase == "finally":
+			when = fmt.Sprintf("finally, step '%s'", record.Step)
+		default:
+			when = fmt.Sprintf("repetition %d, %s, step '%s'", record.Repetition, record.Phase, record.Step)
+		}
+		fmt.Printf("==   %s: %s\n", when, record)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// test that steps after a repartition are validated against the new subsets
+func TestValidateRepartitions(t *testing.T) {
+	test, err := loadTest("testutils/repartition.yml", newTestConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	subsetPartition, err := partition(test.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validate(test, subsetPartition); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		step    Step
+		config  Config
+		message string
+	}{
+		{Step{Repartition: &SubsetPartition{}}, Config{Nodes: 6}, "no partition in header"},
+		{Step{Repartition: &SubsetPartition{EachRepetition: true}}, test.Config, "each_repetition"},
+		{Step{Repartition: &SubsetPartition{PartitionType: even, Order: "SOMETIMES", NumberPartitions: 2}}, test.Config, "invalid ordering"},
+		{Step{Repartition: &SubsetPartition{PartitionType: weighted, Order: random, Percents: []int{50, 60}}}, test.Config, "on test step 0"},
+	} {
+		err := validateRepartitions([]Step{c.step}, c.config)
+		if err == nil || !strings.Contains(err.Error(), c.message) {
+			t.Errorf("Expected an error containing %q, got %v", c.message, err)
+		}
+	}
+}
+
+// test that a repartition step changes the subsets in place and is recorded
+func TestHandleRepartition(t *testing.T) {
+	test, err := loadTest("testutils/repartition.yml", newTestConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	test.Config.Seed = 7
+	subsetPartition, err := seededPartition(test.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subsetPartition[1]) != 2 || len(subsetPartition[2]) != 4 {
+		t.Fatalf("Unexpected header partition: %v", subsetPartition)
+	}
+
+	summary := Summary{TestsRan: 1}
+	handleRepartition(&test.Steps[2], &summary, test.Config, subsetPartition, "steps")
+	if len(subsetPartition) != 3 || summary.Failures != 0 {
+		t.Fatalf("Expected 3 subsets, got %v", subsetPartition)
+	}
+	nodes := make([]int, 0)
+	for subset := 1; subset <= 3; subset++ {
+		if len(subsetPartition[subset]) != 2 {
+			t.Fatalf("Expected subsets of 2 nodes, got %v", subsetPartition)
+		}
+		nodes = append(nodes, subsetPartition[subset]...)
+	}
+	if len(nodes) != 6 || max(nodes) != 6 || !allPositive(nodes) {
+		t.Fatalf("Subsets don't cover every node: %v", subsetPartition)
+	}
+
+	record := summary.Partitions[0]
+	if record.Repetition != 2 || record.Phase != "steps" || record.Step != "split in three" ||
+		!reflect.DeepEqual(record.Subsets, subsetPartition) {
+		t.Fatalf("Unexpected record: %+v", record)
+	}
+
+	/* Without partition_type the header partition is made again */
+	handleRepartition(&test.Steps[1], &summary, test.Config, subsetPartition, "finally")
+	if len(subsetPartition) != 2 || len(subsetPartition[1]) != 2 {
+		t.Fatalf("Expected the header partition, got %v", subsetPartition)
+	}
+	if record := summary.Partitions[1]; record.Repetition != 0 || record.Phase != "finally" {
+		t.Fatalf("Unexpected record: %+v", record)
+	}
+}
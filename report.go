@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+)
+
+// Report is the machine readable outcome of a run, written by --report
+type Report struct {
+	Created time.Time    `json:"created"`
+	Tests   []ReportTest `json:"tests"`
+}
+
+// ReportTest is the outcome of one test in a report
+type ReportTest struct {
+	File     string   `json:"file"`
+	Name     string   `json:"name,omitempty"`
+	Matrix   Params   `json:"matrix,omitempty"`
+	Seed     int64    `json:"seed,omitempty"`
+	Revision string   `json:"revision,omitempty"` /* Of the image the test ran against */
+	Status   string   `json:"status"`             /* pass, fail or error */
+	Error    string   `json:"error,omitempty"`
+	Summary  *Summary `json:"summary,omitempty"`
+}
+
+func newReport(results []*RunResult) Report {
+	report := Report{Created: time.Now(), Tests: make([]ReportTest, 0, len(results))}
+	for _, result := range results {
+		test := ReportTest{
+			File:     result.File,
+			Name:     result.Name,
+			Matrix:   result.Matrix,
+			Seed:     result.Seed,
+			Revision: result.Revision,
+		}
+		switch {
+		case result.Err != nil:
+			test.Status = "error"
+			test.Error = result.Err.Error()
+		case result.passed():
+			test.Status = "pass"
+		default:
+			test.Status = "fail"
+		}
+		/* Tests stopped by an error keep the summary of what ran */
+		if result.Err == nil || !result.Summary.Start.IsZero() {
+			summary := result.Summary
+			test.Summary = &summary
+		}
+		report.Tests = append(report.Tests, test)
+	}
+	return report
+}
+
+// writeReport writes the JSON report of results to path
+func writeReport(path string, results []*RunResult) error {
+	data, err := json.MarshalIndent(newReport(results), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// test that the report holds the status, summary and partitions of each test
+func TestWriteReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	partitions := []PartitionRecord{{Repetition: 1, Subsets: map[int][]int{1: {2}, 2: {1, 3}}}}
+	results := []*RunResult{
+		{File: "a.yml", Name: "a", Seed: 3, Summary: Summary{Successes: 2, Partitions: partitions}},
+		{File: "b.yml", Name: "b", Matrix: Params{"N": "4"}, Status: 1},
+		{File: "c.yml", Err: errors.New("bad test")},
+		{File: "d.yml", Err: errors.New("stopped"), Summary: Summary{Start: time.Unix(1500000000, 0), Failures: 1}},
+	}
+	path := filepath.Join(dir, "report.json")
+	if err := writeReport(path, results); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Tests) != 4 {
+		t.Fatalf("Expected 4 tests, got %d", len(report.Tests))
+	}
+	a, b, c := report.Tests[0], report.Tests[1], report.Tests[2]
+	if a.Status != "pass" || a.Seed != 3 || a.Summary.Successes != 2 || len(a.Summary.Partitions) != 1 ||
+		len(a.Summary.Partitions[0].Subsets[2]) != 2 {
+		t.Errorf("Unexpected report of a: %+v", a)
+	}
+	if b.Status != "fail" || b.Matrix["N"] != "4" {
+		t.Errorf("Unexpected report of b: %+v", b)
+	}
+	if c.Status != "error" || c.Error != "bad test" || c.Summary != nil {
+		t.Errorf("Unexpected report of c: %+v", c)
+	}
+	/* A test stopped by an error keeps the summary of what ran */
+	if d := report.Tests[3]; d.Status != "error" || d.Summary == nil || d.Summary.Failures != 1 {
+		t.Errorf("Unexpected report of d: %+v", d)
+	}
+}
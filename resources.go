@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+/* Seconds between resource samples by default */
+const defaultSamplingInterval = 5
+
+// resourcesCommand prints the CPU time in nanoseconds, the memory in bytes
+// and the bytes received and sent by the pod, from its cgroup, version 2
+// or 1, and from the counters of its network interfaces
+const resourcesCommand = `if [ -f /sys/fs/cgroup/cpu.stat ]; then
+cpu=$(awk '$1 == "usage_usec" {print $2}' /sys/fs/cgroup/cpu.stat)000
+mem=$(cat /sys/fs/cgroup/memory.current)
+else
+cpu=$(cat /sys/fs/cgroup/cpuacct/cpuacct.usage)
+mem=$(cat /sys/fs/cgroup/memory/memory.usage_in_bytes)
+fi
+echo $cpu $mem $(awk 'NR > 2 {sub(/:/, " "); if ($1 != "lo") {rx += $2; tx += $10}} END {printf "%d %d", rx, tx}' /proc/net/dev)`
+
+// Sampling samples the CPU, memory and network use of the selected pods
+// while command steps run
+type Sampling struct {
+	Interval int `yaml:"interval"` /* Seconds between samples, default 5 */
+}
+
+func (sampling *Sampling) interval() time.Duration {
+	if sampling.Interval <= 0 {
+		return defaultSamplingInterval * time.Second
+	}
+	return time.Duration(sampling.Interval) * time.Second
+}
+
+// resourceSample is the resource use of a pod at some time.  CPU and
+// network are counters since the start of the container.
+type resourceSample struct {
+	time   time.Time
+	cpu    int64 /* Nanoseconds */
+	memory int64
+	rx     int64
+	tx     int64
+}
+
+func parseResourceSample(out string, at time.Time) (resourceSample, error) {
+	fields := strings.Fields(out)
+	if len(fields) != 4 {
+		return resourceSample{}, fmt.Errorf("unexpected resource sample %q", strings.TrimSpace(out))
+	}
+	values := make([]int64, 4)
+	for i, field := range fields {
+		value, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return resourceSample{}, fmt.Errorf("unexpected resource sample %q", strings.TrimSpace(out))
+		}
+		values[i] = value
+	}
+	return resourceSample{at, values[0], values[1], values[2], values[3]}, nil
+}
+
+// ResourceStat summarizes the values of a resource over the samples of all
+// the nodes of a step.  Peak is the node the maximum was seen on.
+type ResourceStat struct {
+	Min  float64 `json:"min"`
+	Avg  float64 `json:"avg"`
+	Max  float64 `json:"max"`
+	Peak int     `json:"peak_node"`
+}
+
+// StepResources is the resource use of the nodes of a step, over all of its
+// iterations in a repetition
+type StepResources struct {
+	Repetition int          `json:"repetition"` /* 0 for the finally phase */
+	Phase      string       `json:"phase"`
+	Step       string       `json:"step"`
+	Iterations int          `json:"iterations"`
+	Nodes      []int        `json:"nodes"`
+	Samples    int          `json:"samples"`
+	CPU        ResourceStat `json:"cpu_percent"` /* Of a core */
+	Memory     ResourceStat `json:"memory_bytes"`
+	Rx         ResourceStat `json:"rx_bytes_per_second"`
+	Tx         ResourceStat `json:"tx_bytes_per_second"`
+	RxBytes    int64        `json:"rx_bytes"` /* Received by all nodes during the step */
+	TxBytes    int64        `json:"tx_bytes"`
+	iterations []map[int][]resourceSample
+}
+
+/* statAccumulator collects the values of a ResourceStat */
+type statAccumulator struct {
+	stat  ResourceStat
+	sum   float64
+	count int
+}
+
+func (acc *statAccumulator) add(value float64, node int) {
+	if acc.count == 0 || value < acc.stat.Min {
+		acc.stat.Min = value
+	}
+	if acc.count == 0 || value > acc.stat.Max {
+		acc.stat.Max = value
+		acc.stat.Peak = node
+	}
+	acc.sum += value
+	acc.count++
+}
+
+func (acc *statAccumulator) result() ResourceStat {
+	if acc.count != 0 {
+		acc.stat.Avg = acc.sum / float64(acc.count)
+	}
+	return acc.stat
+}
+
+// summarizeResources computes the statistics of the samples of each node
+// over iterations.  Memory is taken from every sample, rates from every
+// pair of consecutive samples of a node in an iteration, since nothing is
+// sampled between iterations.
+func summarizeResources(iterations []map[int][]resourceSample) StepResources {
+	var cpu, memory, rx, tx statAccumulator
+	var resources StepResources
+	nodes := make(map[int]bool)
+	for _, samples := range iterations {
+		for node := range samples {
+			if !nodes[node] {
+				nodes[node] = true
+				resources.Nodes = append(resources.Nodes, node)
+			}
+		}
+	}
+	sort.Ints(resources.Nodes)
+	for _, samples := range iterations {
+		resources.addSamples(samples, &cpu, &memory, &rx, &tx)
+	}
+	resources.Iterations = len(iterations)
+	resources.CPU = cpu.result()
+	resources.Memory = memory.result()
+	resources.Rx = rx.result()
+	resources.Tx = tx.result()
+	return resources
+}
+
+// addSamples accumulates the samples of an iteration
+func (resources *StepResources) addSamples(samples map[int][]resourceSample, cpu, memory, rx, tx *statAccumulator) {
+	for _, node := range resources.Nodes {
+		nodeSamples := samples[node]
+		resources.Samples += len(nodeSamples)
+		for i, sample := range nodeSamples {
+			memory.add(float64(sample.memory), node)
+			if i == 0 {
+				continue
+			}
+			previous := nodeSamples[i-1]
+			seconds := sample.time.Sub(previous.time).Seconds()
+			if seconds <= 0 {
+				continue
+			}
+			cpu.add(float64(sample.cpu-previous.cpu)/1e9/seconds*100, node)
+			rx.add(float64(sample.rx-previous.rx)/seconds, node)
+			tx.add(float64(sample.tx-previous.tx)/seconds, node)
+		}
+		if len(nodeSamples) > 1 {
+			resources.RxBytes += nodeSamples[len(nodeSamples)-1].rx - nodeSamples[0].rx
+			resources.TxBytes += nodeSamples[len(nodeSamples)-1].tx - nodeSamples[0].tx
+		}
+	}
+}
+
+// resourceSampler samples the pods of nodes until it is stopped
+type resourceSampler struct {
+	pods     GetPodsOutput
+	nodes    []int
+	interval time.Duration
+	mutex    sync.Mutex
+	samples  map[int][]resourceSample
+	stop     chan bool
+	done     chan bool
+}
+
+// startSampling samples the pods of nodes once, then every interval in
+// the background until the sampler is stopped
+func startSampling(sampling *Sampling, pods GetPodsOutput, nodes []int) *resourceSampler {
+	sampler := &resourceSampler{
+		pods:     pods,
+		nodes:    nodes,
+		interval: sampling.interval(),
+		samples:  make(map[int][]resourceSample),
+		stop:     make(chan bool),
+		done:     make(chan bool),
+	}
+	sampler.sample()
+	go func() {
+		ticker := time.NewTicker(sampler.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sampler.sample()
+			case <-sampler.stop:
+				close(sampler.done)
+				return
+			}
+		}
+	}()
+	return sampler
+}
+
+// sample samples every pod in parallel.  Pods that fail are left out of
+// the round and reported in debug mode.
+func (sampler *resourceSampler) sample() {
+	var wait sync.WaitGroup
+	for _, node := range sampler.nodes {
+		wait.Add(1)
+		go func(node int) {
+			defer wait.Done()
+			name := sampler.pods.Items[node-1].Metadata.Name
+			out, err := podOutput(name, resourcesCommand, sampler.interval)
+			var sample resourceSample
+			if err == nil {
+				sample, err = parseResourceSample(out, time.Now())
+			}
+			if err != nil {
+				debug(fmt.Sprintf("Failed to sample the resources of node %d (%s): %s", node, name, err))
+				return
+			}
+			sampler.mutex.Lock()
+			sampler.samples[node] = append(sampler.samples[node], sample)
+			sampler.mutex.Unlock()
+		}(node)
+	}
+	wait.Wait()
+}
+
+// finish takes a last sample and returns all of them
+func (sampler *resourceSampler) finish() map[int][]resourceSample {
+	close(sampler.stop)
+	<-sampler.done
+	sampler.sample()
+	return sampler.samples
+}
+
+// podOutput runs a command in a pod and returns its output
+func podOutput(name string, cmdToRun string, timeout time.Duration) (string, error) {
+	cmd := exec.Command("kubectl", "exec", name, "--", "sh", "-c", cmdToRun)
+	var out, errout bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &errout
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	timer := time.AfterFunc(timeout, func() {
+		cmd.Process.Kill()
+	})
+	defer timer.Stop()
+	if err := cmd.Wait(); err != nil {
+		return "", fmt.Errorf("%s: %s", err, strings.TrimSpace(errout.String()))
+	}
+	return out.String(), nil
+}
+
+// addResources adds the samples of an iteration to the resource use of its
+// step in the current repetition
+func (summary *Summary) addResources(phase string, step string, samples map[int][]resourceSample) {
+	if len(samples) == 0 {
+		color.Yellow("### No resource samples for step %s", step)
+		return
+	}
+	repetition := repetitionOf(summary, phase)
+	for i := range summary.Resources {
+		r := &summary.Resources[i]
+		if r.Repetition == repetition && r.Phase == phase && r.Step == step {
+			*r = r.with(samples)
+			return
+		}
+	}
+	resources := StepResources{Repetition: repetition, Phase: phase, Step: step}
+	summary.Resources = append(summary.Resources, resources.with(samples))
+}
+
+// with summarizes the resources again with the samples of one more iteration
+func (resources StepResources) with(samples map[int][]resourceSample) StepResources {
+	iterations := append(resources.iterations, samples)
+	summarized := summarizeResources(iterations)
+	summarized.Repetition = resources.Repetition
+	summarized.Phase = resources.Phase
+	summarized.Step = resources.Step
+	summarized.iterations = iterations
+	return summarized
+}
+
+func formatBytes(value float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f%s", value, units[unit])
+}
+
+func printResources(resources []StepResources) {
+	if len(resources) == 0 {
+		return
+	}
+	fmt.Println("== Resources (min/avg/max, peak node):")
+	for _, r := range resources {
+		fmt.Printf("==   %s, step '%s' over %d iterations on nodes %v:\n", whenLabel(r.Repetition, r.Phase), r.Step, r.Iterations, r.Nodes)
+		fmt.Printf("==     cpu %.0f%%/%.0f%%/%.0f%% (%d), memory %s/%s/%s (%d)\n",
+			r.CPU.Min, r.CPU.Avg, r.CPU.Max, r.CPU.Peak,
+			formatBytes(r.Memory.Min), formatBytes(r.Memory.Avg), formatBytes(r.Memory.Max), r.Memory.Peak)
+		fmt.Printf("==     received %s at %s/s max (%d), sent %s at %s/s max (%d)\n",
+			formatBytes(float64(r.RxBytes)), formatBytes(r.Rx.Max), r.Rx.Peak,
+			formatBytes(float64(r.TxBytes)), formatBytes(r.Tx.Max), r.Tx.Peak)
+	}
+}
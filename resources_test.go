@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseResourceSample(t *testing.T) {
+	now := time.Now()
+	sample, err := parseResourceSample("2500000000 1048576 300 400\n", now)
+	if err != nil || sample != (resourceSample{now, 2500000000, 1048576, 300, 400}) {
+		t.Fatalf("Unexpected sample %+v, %v", sample, err)
+	}
+	for _, out := range []string{"", "1 2 3", "1 2 3 x", "cat: /sys/fs/cgroup/memory.current: No such file"} {
+		if _, err := parseResourceSample(out, now); err == nil {
+			t.Errorf("Expected an error for %q", out)
+		}
+	}
+}
+
+func TestSummarizeResources(t *testing.T) {
+	start := time.Now()
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+	resources := summarizeResources([]map[int][]resourceSample{{
+		/* Half a core, then a full core */
+		2: {{at(0), 0, 100, 0, 0}, {at(2), 1e9, 300, 2000, 100}, {at(4), 3e9, 200, 2000, 300}},
+		5: {{at(0), 0, 400, 1000, 0}, {at(2), 0, 400, 1000, 0}},
+	}})
+	if !reflect.DeepEqual(resources.Nodes, []int{2, 5}) || resources.Samples != 5 {
+		t.Fatalf("Unexpected nodes %v or samples %d", resources.Nodes, resources.Samples)
+	}
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+	expect := func(name string, stat ResourceStat, min, avg, max float64, peak int) {
+		if !near(stat.Min, min) || !near(stat.Avg, avg) || !near(stat.Max, max) || stat.Peak != peak {
+			t.Errorf("%s: expected %g/%g/%g (%d), got %+v", name, min, avg, max, peak, stat)
+		}
+	}
+	expect("cpu", resources.CPU, 0, 50, 100, 2)
+	expect("memory", resources.Memory, 100, 280, 400, 5)
+	expect("rx", resources.Rx, 0, 1000.0/3, 1000, 2)
+	expect("tx", resources.Tx, 0, 50, 100, 2)
+	if resources.RxBytes != 2000 || resources.TxBytes != 300 {
+		t.Errorf("Unexpected totals %d and %d", resources.RxBytes, resources.TxBytes)
+	}
+}
+
+func TestAddResources(t *testing.T) {
+	start := time.Now()
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+	summary := Summary{TestsRan: 1}
+	summary.addResources("steps", "add", map[int][]resourceSample{1: {{at(0), 0, 10, 0, 0}, {at(1), 1e9, 10, 100, 0}}})
+	summary.addResources("finally", "clean", map[int][]resourceSample{})
+	/* The next iteration counts from its own first sample, the idle time
+	   between iterations is left out */
+	summary.addResources("steps", "add", map[int][]resourceSample{2: {{at(60), 5e9, 30, 100, 0}, {at(62), 5e9, 20, 300, 0}}})
+	if len(summary.Resources) != 1 {
+		t.Fatalf("Expected one entry for the step with samples, got %+v", summary.Resources)
+	}
+	r := summary.Resources[0]
+	if r.Repetition != 2 || r.Phase != "steps" || r.Step != "add" || r.Iterations != 2 || r.Samples != 4 {
+		t.Fatalf("Unexpected resources %+v", r)
+	}
+	if !reflect.DeepEqual(r.Nodes, []int{1, 2}) || r.Memory.Max != 30 || r.Memory.Peak != 2 || r.CPU.Max != 100 || r.CPU.Min != 0 || r.RxBytes != 300 {
+		t.Fatalf("Unexpected statistics %+v", r)
+	}
+
+	/* The same step in the next repetition has its own entry */
+	summary.TestsRan++
+	summary.addResources("steps", "add", map[int][]resourceSample{1: {{at(90), 0, 10, 0, 0}}})
+	if len(summary.Resources) != 2 || summary.Resources[1].Repetition != 3 || summary.Resources[1].Iterations != 1 {
+		t.Fatalf("Unexpected resources %+v", summary.Resources)
+	}
+	if formatBytes(1536) != "1.5KiB" || formatBytes(12) != "12.0B" {
+		t.Errorf("Unexpected formatting %s, %s", formatBytes(1536), formatBytes(12))
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// formats counts the ways of picking nodes the selection uses, of which
+// there may be only one
+func (selection *Selection) formats() int {
+	count := 0
+	if selection.Range != nil {
+		count++
+	}
+	if selection.Percent != nil {
+		count++
+	}
+	if selection.Nodes != nil {
+		count++
+	}
+	if selection.ComplementOf != "" {
+		count++
+	}
+	return count
+}
+
+// selectsMatching tells whether the selection narrows the nodes down
+// enough to select every remaining node without a range, percent or list
+func (selection *Selection) selectsMatching() bool {
+	return selection.filtersPods() || selection.From != "" || selection.NotIn != ""
+}
+
+// filtersPods tells whether the selection depends on the metadata of
+// the pods, which is only known at run time
+func (selection *Selection) filtersPods() bool {
+	return len(selection.Labels) != 0 || len(selection.Annotations) != 0 || selection.KubeNode != ""
+}
+
+// filter returns the nodes of pool that are not excepted and whose pods
+// match the selection.  Pods are not filtered when pods is nil.
+func (selection *Selection) filter(pool []int, pods *GetPodsOutput) []int {
+	filtered := make([]int, 0, len(pool))
+	for _, node := range pool {
+		if containsInt(selection.Except, node) {
+			continue
+		}
+		if pods != nil && selection.filtersPods() {
+			if node > len(pods.Items) || !pods.Items[node-1].matches(selection) {
+				continue
+			}
+		}
+		filtered = append(filtered, node)
+	}
+	return filtered
+}
+
+// narrow applies the selections saved by earlier steps to pool
+func (selection *Selection) narrow(pool []int, envArrays map[string][]string) []int {
+	if selection.From == "" && selection.NotIn == "" && selection.ComplementOf == "" {
+		return pool
+	}
+	narrowed := make([]int, 0, len(pool))
+	for _, node := range pool {
+		switch {
+		case selection.From != "" && !savedNode(envArrays, selection.From, node):
+		case selection.NotIn != "" && savedNode(envArrays, selection.NotIn, node):
+		case selection.ComplementOf != "" && savedNode(envArrays, selection.ComplementOf, node):
+		default:
+			narrowed = append(narrowed, node)
+		}
+	}
+	return narrowed
+}
+
+// saveSelection adds the nodes selected by an iteration of a step to the
+// array of selected nodes, each node once
+func saveSelection(envArrays map[string][]string, name string, nodes []int) {
+	for _, node := range nodes {
+		if !savedNode(envArrays, name, node) {
+			envArrays[name] = append(envArrays[name], strconv.Itoa(node))
+		}
+	}
+}
+
+func savedNode(envArrays map[string][]string, name string, node int) bool {
+	return containsString(envArrays[name], strconv.Itoa(node))
+}
+
+func (selection *Selection) validateLists(config Config) error {
+	for _, node := range selection.Nodes {
+		if node < 1 || node > config.Nodes {
+			return fmt.Errorf("Invalid node %d in nodes list", node)
+		}
+	}
+	for _, node := range selection.Except {
+		if node < 1 || node > config.Nodes {
+			return fmt.Errorf("Invalid node %d in except list", node)
+		}
+	}
+	if len(selection.Nodes) != 0 && len(selection.filter(selection.Nodes, nil)) == 0 {
+		return fmt.Errorf("Every node of the nodes list is excepted")
+	}
+	return nil
+}
+
+// matches tells whether the pod has the labels and annotations of the
+// selection and runs on its kubernetes node
+func (pod Pod) matches(selection *Selection) bool {
+	for key, value := range selection.Labels {
+		if actual, ok := pod.Metadata.Labels[key]; !ok || actual != value {
+			return false
+		}
+	}
+	for key, value := range selection.Annotations {
+		if actual, ok := pod.Metadata.Annotations[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return selection.KubeNode == "" || pod.Spec.NodeName == selection.KubeNode
+}
+
+func containsInt(list []int, n int) bool {
+	for _, item := range list {
+		if item == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const podListJSON = `{"items": [
+	{"metadata": {"name": "ipfs-0", "labels": {"role": "bootstrapper"}}, "spec": {"nodeName": "kube-a"}},
+	{"metadata": {"name": "ipfs-1", "labels": {"role": "peer"}, "annotations": {"slow": "true"}}, "spec": {"nodeName": "kube-a"}},
+	{"metadata": {"name": "ipfs-2", "labels": {"role": "peer"}}, "spec": {"nodeName": "kube-b"}},
+	{"metadata": {"name": "ipfs-3", "labels": {"role": "peer"}}, "spec": {"nodeName": "kube-b"}}
+]}`
+
+// test that nodes are selected by the metadata of their pods
+func TestSelectByPodMetadata(t *testing.T) {
+	var pods GetPodsOutput
+	if err := json.Unmarshal([]byte(podListJSON), &pods); err != nil {
+		t.Fatal(err)
+	}
+	config := Config{Nodes: 4}
+	cases := []struct {
+		selection Selection
+		nodes     []int
+	}{
+		{Selection{Labels: map[string]string{"role": "peer"}}, []int{2, 3, 4}},
+		{Selection{Labels: map[string]string{"role": "peer"}, Except: []int{3}}, []int{2, 4}},
+		{Selection{Annotations: map[string]string{"slow": "true"}}, []int{2}},
+		{Selection{KubeNode: "kube-a"}, []int{1, 2}},
+		{Selection{KubeNode: "kube-b", Nodes: []int{1, 2, 3}}, []int{3}},
+		{Selection{Labels: map[string]string{"role": "peer"}, Range: &Range{Order: sequential, Start: 2, End: 3}}, []int{3, 4}},
+		{Selection{Labels: map[string]string{"role": "peer"}, Range: &Range{Order: random, Number: 4}}, []int{-1, -1, -1}},
+	}
+	for i, c := range cases {
+		if err := validateSelections([]Step{{Selection: &c.selection}}, nil, config); err != nil {
+			t.Errorf("Case %d: %s", i, err)
+			continue
+		}
+		nodes := selectNodes(Step{Selection: &c.selection}, config, nil, &pods, nil)
+		if c.nodes[0] < 0 {
+			if len(nodes) != len(c.nodes) {
+				t.Errorf("Case %d: expected %d nodes, got %v", i, len(c.nodes), nodes)
+			}
+		} else if !slicesEqual(nodes, c.nodes) {
+			t.Errorf("Case %d: expected %v, got %v", i, c.nodes, nodes)
+		}
+	}
+}
+
+func TestValidateNodeLists(t *testing.T) {
+	config := Config{Nodes: 4}
+	for i, selection := range []Selection{
+		{Nodes: []int{0}},
+		{Nodes: []int{1}, Except: []int{5}},
+		{Nodes: []int{2}, Except: []int{2}},
+		{Except: []int{1}},
+	} {
+		if err := validateSelections([]Step{{Selection: &selection}}, nil, config); err == nil {
+			t.Errorf("Case %d should not validate", i)
+		}
+	}
+}
+
+// test that steps can select relative to the nodes of earlier steps
+func TestSavedSelections(t *testing.T) {
+	config := Config{Nodes: 5}
+	envArrays := make(map[string][]string)
+	adder := Step{
+		Selection:     &Selection{Range: &Range{Order: random, Number: 1}},
+		SaveSelection: "ADDER",
+	}
+	if err := validateSelections([]Step{adder}, nil, config); err != nil {
+		t.Fatal(err)
+	}
+	added := selectNodes(adder, config, nil, nil, envArrays)
+	saveSelection(envArrays, adder.SaveSelection, added)
+	if len(added) != 1 || len(envArrays["ADDER"]) != 1 {
+		t.Fatalf("Unexpected selection %v saved as %v", added, envArrays["ADDER"])
+	}
+
+	saveSelection(envArrays, "FIRST", []int{1, 2})
+	saveSelection(envArrays, "FIRST", []int{2, 3})
+	if len(envArrays["FIRST"]) != 3 {
+		t.Fatalf("Saved selection should hold each node once: %v", envArrays["FIRST"])
+	}
+
+	same := selectNodes(Step{Selection: &Selection{From: "ADDER"}}, config, nil, nil, envArrays)
+	if !slicesEqual(same, added) {
+		t.Errorf("from: expected %v, got %v", added, same)
+	}
+	others := selectNodes(Step{Selection: &Selection{ComplementOf: "ADDER"}}, config, nil, nil, envArrays)
+	if len(others) != 4 || containsInt(others, added[0]) {
+		t.Errorf("complement_of: expected every node but %v, got %v", added, others)
+	}
+	notIn := selectNodes(Step{Selection: &Selection{NotIn: "FIRST", Range: &Range{Order: sequential, Start: 1, End: 1}}}, config, nil, nil, envArrays)
+	if !slicesEqual(notIn, []int{4}) {
+		t.Errorf("not_in: expected [4], got %v", notIn)
+	}
+
+	bad := Step{Selection: &Selection{ComplementOf: "ADDER", Nodes: []int{1}}}
+	if err := validateSelections([]Step{bad}, nil, config); err == nil {
+		t.Error("complement_of with a nodes list should not validate")
+	}
+}
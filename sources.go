@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Environment variables named KIPFS_PARAM_<NAME> set parameter <NAME>
+const envParamPrefix = "KIPFS_PARAM_"
+
+// paramSources are the places test parameters are read from.  From
+// lowest to highest priority: the defaults declared by the test, the
+// config files in the order they were given, KIPFS_PARAM_* environment
+// variables and `--param` flags.
+type paramSources struct {
+	configFiles stringList /* config.yml next to the test if empty */
+	cli         Params
+	cliOrigins  map[string]string
+}
+
+func newParamSources() paramSources {
+	return paramSources{
+		cli:        make(Params),
+		cliOrigins: make(map[string]string),
+	}
+}
+
+func (sources *paramSources) String() string {
+	return fmt.Sprint(sources.cli)
+}
+
+// Set parses a `--param` flag.  A value starting with @ names a file
+// whose content, without its trailing newline, is the value.
+func (sources *paramSources) Set(input string) error {
+	params := make(Params)
+	if err := params.Set(input); err != nil {
+		return err
+	}
+	for name, value := range params {
+		origin := "--param"
+		if strings.HasPrefix(value, "@") {
+			file := value[1:]
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("Parameter %s: %s", name, err)
+			}
+			value = strings.TrimSuffix(string(data), "\n")
+			origin = "--param " + name + "=@" + file
+		}
+		sources.cli[name] = value
+		sources.cliOrigins[name] = origin
+	}
+	return nil
+}
+
+// envParams returns the parameters set in environ, a list of KEY=value
+func envParams(environ []string) Params {
+	params := make(Params)
+	for _, entry := range environ {
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || !strings.HasPrefix(kv[0], envParamPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(kv[0], envParamPrefix)
+		if pNameRegex.MatchString(name) {
+			params[name] = kv[1]
+		}
+	}
+	return params
+}
+
+// loadTestConfig combines the parameters for a test file from all
+// sources, recording where each value came from
+func loadTestConfig(filePath string, sources paramSources) (TestConfig, error) {
+	testConfig := newTestConfig()
+	if len(sources.configFiles) == 0 {
+		// default params file to test directory (if not specified)
+		configPath := filepath.Join(filepath.Dir(filePath), "config.yml")
+		fileConfig, err := loadConfigFile(configPath)
+		// if default config not found, print Warning and continue
+		if err != nil {
+			fmt.Printf("Warning: %s\n", err)
+		} else {
+			testConfig.merge(fileConfig, configPath)
+		}
+	}
+	for _, configPath := range sources.configFiles {
+		fileConfig, err := loadConfigFile(configPath)
+		// if input config file not found, report error/quit
+		if err != nil {
+			return testConfig, err
+		}
+		testConfig.merge(fileConfig, configPath)
+	}
+
+	for name, value := range envParams(os.Environ()) {
+		testConfig.addParam(name, value, "env "+envParamPrefix+name)
+	}
+	for name, value := range sources.cli {
+		testConfig.addParam(name, value, sources.cliOrigins[name])
+	}
+	return testConfig, nil
+}
+
+// merge adds the parameters, sweeps and matrix exclusions of a config
+// file, replacing the values set by earlier files
+func (config *TestConfig) merge(other TestConfig, origin string) {
+	for name, value := range other.Params {
+		config.addParam(name, value, origin)
+	}
+	for name, values := range other.Sweeps {
+		delete(config.Params, name)
+		config.Sweeps[name] = values
+		config.Origins[name] = origin
+	}
+	config.MatrixExclude = append(config.MatrixExclude, other.MatrixExclude...)
+}
+
+func (config TestConfig) addParam(name string, value string, origin string) {
+	config.addParams(Params{name: value})
+	config.Origins[name] = origin
+}
+
+// printParams prints the value every parameter of a test file resolves
+// to and where that value came from
+func printParams(filePath string, config TestConfig) error {
+	fileData, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	schema, err := loadParamSchema(fileData)
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]bool)
+	for name := range config.Params {
+		names[name] = true
+	}
+	for name := range config.Sweeps {
+		names[name] = true
+	}
+	for name := range schema {
+		names[name] = true
+	}
+	for _, pExpr := range uniqueParamDeclarations(fileData) {
+		if node, err := parseExpr(pExpr); err == nil {
+			for _, name := range node.variables() {
+				names[name] = true
+			}
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVALUE\tORIGIN")
+	for _, name := range sorted {
+		value, origin := "-", "(not set)"
+		spec, declared := schema[name]
+		if v, ok := config.Params[name]; ok {
+			value, origin = v, config.Origins[name]
+		} else if values, ok := config.Sweeps[name]; ok {
+			value, origin = "["+strings.Join(values, " ")+"]", config.Origins[name]+" (swept)"
+		} else if declared && spec.Default != nil {
+			value, origin = fmt.Sprint(spec.Default), "default"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, value, origin)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// test that later config files, the environment and --param override
+// earlier sources in that order
+func TestParamPrecedence(t *testing.T) {
+	sources := newParamSources()
+	sources.configFiles.Set("testutils/add-and-gc-config.yml,testutils/override-config.yml")
+	if err := sources.Set("NUM_TIMES=7"); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("KIPFS_PARAM_NUM_TIMES", "5")
+	os.Setenv("KIPFS_PARAM_ON_NODE", "4")
+	defer os.Unsetenv("KIPFS_PARAM_NUM_TIMES")
+	defer os.Unsetenv("KIPFS_PARAM_ON_NODE")
+
+	config, err := loadTestConfig("tests/add-and-gc-template.yml", sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][2]string{
+		"NUM_NODES": {"3", "testutils/override-config.yml"},
+		"ON_NODE":   {"4", "env KIPFS_PARAM_ON_NODE"},
+		"NUM_TIMES": {"7", "--param"},
+	}
+	for name, want := range expected {
+		if config.Params[name] != want[0] || config.Origins[name] != want[1] {
+			t.Errorf("%s: got %q from %q, expected %q from %q",
+				name, config.Params[name], config.Origins[name], want[0], want[1])
+		}
+	}
+	if _, ok := config.Params["FILE_SIZE"]; ok || len(config.Sweeps["FILE_SIZE"]) != 2 {
+		t.Errorf("FILE_SIZE should be swept by the second config file: %v", config.Sweeps)
+	}
+}
+
+// test that --param NAME=@file reads the value from a file
+func TestParamFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kipfs-params")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "peers")
+	if err := ioutil.WriteFile(file, []byte("a b c\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sources := newParamSources()
+	if err := sources.Set("PEERS=@" + file); err != nil {
+		t.Fatal(err)
+	}
+	if sources.cli["PEERS"] != "a b c" || sources.cliOrigins["PEERS"] != "--param PEERS=@"+file {
+		t.Fatalf("Unexpected value %q from %q", sources.cli["PEERS"], sources.cliOrigins["PEERS"])
+	}
+	if err := sources.Set("PEERS=@" + filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("Missing parameter file should fail")
+	}
+}
+
+func TestEnvParams(t *testing.T) {
+	params := envParams([]string{"HOME=/root", "KIPFS_PARAM_N=3", "KIPFS_PARAM_=x", "KIPFS_PARAM_A-B=1"})
+	if len(params) != 1 || params["N"] != "3" {
+		t.Fatalf("Unexpected environment parameters: %v", params)
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+/* Layout of the ids of stored runs, which sort by time */
+const runIDLayout = "20060102T150405"
+
+// Latency changes smaller than this are noise, whatever the threshold
+const minRegression = 10 * time.Millisecond
+
+// saveRun stores the report of a run in dir, under an id made from the
+// time it was created, and returns the id
+func saveRun(dir string, results []*RunResult) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	report := newReport(results)
+	id := report.Created.Format(runIDLayout)
+	/* Runs created within the same second get a suffix */
+	for n := 2; ; n++ {
+		if _, err := os.Stat(filepath.Join(dir, id+".json")); os.IsNotExist(err) {
+			break
+		}
+		id = report.Created.Format(runIDLayout) + "-" + strconv.Itoa(n)
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return id, ioutil.WriteFile(filepath.Join(dir, id+".json"), append(data, '\n'), 0644)
+}
+
+// runRevision is the revision a test ran against: the one given with
+// --revision, or else the image of its pods
+func runRevision(revision string, summary Summary) string {
+	if revision != "" {
+		return revision
+	}
+	return summary.Image
+}
+
+// storeRun saves the results in dir if it is set, and prints the id of
+// the run for compare
+func storeRun(dir string, results []*RunResult) {
+	if dir == "" {
+		return
+	}
+	id, err := saveRun(dir, results)
+	if err != nil {
+		fatal(err)
+	}
+	color.Cyan("## Stored run %s in %s", id, dir)
+}
+
+// parseRunID returns the time and the collision suffix of a run id, or
+// false if id was not made by saveRun
+func parseRunID(id string) (time.Time, int, bool) {
+	stamp, suffix := id, 1
+	if i := strings.Index(id, "-"); i >= 0 {
+		n, err := strconv.Atoi(id[i+1:])
+		if err != nil || n < 2 {
+			return time.Time{}, 0, false
+		}
+		stamp, suffix = id[:i], n
+	}
+	created, err := time.Parse(runIDLayout, stamp)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	return created, suffix, true
+}
+
+// listRuns returns the ids of the runs stored in dir, oldest first.
+// Other files, like a copied baseline.json, are left out.
+func listRuns(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	type storedRun struct {
+		id      string
+		created time.Time
+		suffix  int
+	}
+	runs := make([]storedRun, 0, len(files))
+	for _, file := range files {
+		id := strings.TrimSuffix(filepath.Base(file), ".json")
+		if created, suffix, ok := parseRunID(id); ok {
+			runs = append(runs, storedRun{id, created, suffix})
+		}
+	}
+	/* 20171010T101010-10 comes after 20171010T101010-9 */
+	sort.Slice(runs, func(i, j int) bool {
+		if !runs[i].created.Equal(runs[j].created) {
+			return runs[i].created.Before(runs[j].created)
+		}
+		return runs[i].suffix < runs[j].suffix
+	})
+	ids := make([]string, 0, len(runs))
+	for _, run := range runs {
+		ids = append(ids, run.id)
+	}
+	return ids, nil
+}
+
+// resolveRun returns the path of a run given as a file, an id in dir, or
+// latest, latest~1 and so on for the most recent runs
+func resolveRun(dir string, run string) (string, error) {
+	if info, err := os.Stat(run); err == nil && !info.IsDir() {
+		return run, nil
+	}
+	if strings.HasPrefix(run, "latest") {
+		back := 0
+		if rest := strings.TrimPrefix(run, "latest"); rest != "" {
+			n, err := strconv.Atoi(strings.TrimPrefix(rest, "~"))
+			if err != nil || !strings.HasPrefix(rest, "~") || n < 0 {
+				return "", fmt.Errorf("Invalid run %s, expected latest~<n>", run)
+			}
+			back = n
+		}
+		ids, err := listRuns(dir)
+		if err != nil {
+			return "", err
+		}
+		if back >= len(ids) {
+			return "", fmt.Errorf("Only %d runs are stored in %s", len(ids), dir)
+		}
+		run = ids[len(ids)-1-back]
+	}
+	path := filepath.Join(dir, run+".json")
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("No run %s in %s", run, dir)
+	}
+	return path, nil
+}
+
+func loadRun(path string) (Report, error) {
+	var report Report
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return report, err
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return report, fmt.Errorf("%s: %s", path, err)
+	}
+	return report, nil
+}
+
+// key identifies a test across runs by its name and swept parameters
+func (test ReportTest) key() string {
+	if test.Name == "" {
+		return test.File
+	}
+	if matrix := test.Matrix.label(); matrix != "" {
+		return test.Name + " (" + matrix + ")"
+	}
+	return test.Name
+}
+
+// Finding is a difference between a run and its baseline
+type Finding struct {
+	Test       string
+	Message    string
+	Regression bool /* New failures and slower steps */
+}
+
+func stepKey(phase string, step string) string {
+	return phase + " step '" + step + "'"
+}
+
+// compareRuns compares the tests of run with those of baseline.  Steps are
+// slower if their median or 95th percentile duration grew by more than
+// threshold percent.
+func compareRuns(baseline Report, run Report, threshold float64) []Finding {
+	findings := make([]Finding, 0)
+	old := make(map[string]ReportTest)
+	for _, test := range baseline.Tests {
+		old[test.key()] = test
+	}
+	seen := make(map[string]bool)
+	for _, test := range run.Tests {
+		key := test.key()
+		seen[key] = true
+		before, ok := old[key]
+		if !ok {
+			findings = append(findings, Finding{key, "new test, " + test.Status, test.Status != "pass"})
+			continue
+		}
+		if before.Revision != test.Revision && before.Revision != "" && test.Revision != "" {
+			findings = append(findings, Finding{key, fmt.Sprintf("revision %s -> %s", before.Revision, test.Revision), false})
+		}
+		switch {
+		case before.Status == "pass" && test.Status != "pass":
+			message := "now " + test.Status + "s"
+			if test.Error != "" {
+				message += ": " + test.Error
+			}
+			findings = append(findings, Finding{key, message, true})
+		case before.Status != "pass" && test.Status == "pass":
+			findings = append(findings, Finding{key, "now passes, " + before.Status + "ed before", false})
+		}
+		if before.Summary != nil && test.Summary != nil {
+			findings = append(findings, compareSteps(key, *before.Summary, *test.Summary, threshold)...)
+		}
+	}
+	for _, test := range baseline.Tests {
+		if !seen[test.key()] {
+			findings = append(findings, Finding{test.key(), "not run", false})
+		}
+	}
+	return findings
+}
+
+func compareSteps(key string, before Summary, after Summary, threshold float64) []Finding {
+	findings := make([]Finding, 0)
+	outcomes := make(map[string]StepOutcome)
+	for _, outcome := range before.Steps {
+		outcomes[stepKey(outcome.Phase, outcome.Step)] = outcome
+	}
+	for _, outcome := range after.Steps {
+		step := stepKey(outcome.Phase, outcome.Step)
+		old, ok := outcomes[step]
+		if !ok {
+			continue
+		}
+		if outcome.Failures > old.Failures {
+			findings = append(findings, Finding{key, fmt.Sprintf("%s: %d failures, %d before", step, outcome.Failures, old.Failures), true})
+		}
+		if outcome.Timeouts > old.Timeouts {
+			findings = append(findings, Finding{key, fmt.Sprintf("%s: %d timeouts, %d before", step, outcome.Timeouts, old.Timeouts), true})
+		}
+	}
+	timings := make(map[string]StepTiming)
+	for _, timing := range before.Timings {
+		timings[stepKey(timing.Phase, timing.Step)] = timing
+	}
+	for _, timing := range after.Timings {
+		step := stepKey(timing.Phase, timing.Step)
+		old, ok := timings[step]
+		if !ok {
+			continue
+		}
+		for _, stat := range []struct {
+			name          string
+			before, after float64
+		}{{"p50", old.P50, timing.P50}, {"p95", old.P95, timing.P95}} {
+			grew := stat.after - stat.before
+			if grew > minRegression.Seconds() && grew > stat.before*threshold/100 {
+				findings = append(findings, Finding{key, fmt.Sprintf("%s: %s %.3fs, %.3fs before (+%.0f%%)",
+					step, stat.name, stat.after, stat.before, percentChange(stat.before, stat.after)), true})
+			}
+		}
+	}
+	return findings
+}
+
+func percentChange(before float64, after float64) float64 {
+	if before == 0 {
+		return 100
+	}
+	return (after - before) / before * 100
+}
+
+// runCompare runs the compare subcommand and returns its exit status
+func runCompare(args []string) int {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	flags.Usage = usage
+	dir := flags.String("store", "runs", "Directory of the stored runs, as given to --store")
+	threshold := flags.Float64("threshold", 20, "Flag steps whose median or 95th percentile duration grew by more than `<percent>`")
+	flags.Parse(args)
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		usage()
+		return 1
+	}
+	runPath, err := resolveRun(*dir, flags.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	/* Without a baseline, compare with the run stored before */
+	var baselinePath string
+	if flags.NArg() == 2 {
+		baselinePath, err = resolveRun(*dir, flags.Arg(1))
+	} else {
+		baselinePath, err = previousRun(*dir, runPath)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	run, err := loadRun(runPath)
+	if err != nil {
+		fatal(err)
+	}
+	baseline, err := loadRun(baselinePath)
+	if err != nil {
+		fatal(err)
+	}
+	return printComparison(baselinePath, runPath, compareRuns(baseline, run, *threshold))
+}
+
+// previousRun returns the run stored in dir just before the one at path
+func previousRun(dir string, path string) (string, error) {
+	ids, err := listRuns(dir)
+	if err != nil {
+		return "", err
+	}
+	id := strings.TrimSuffix(filepath.Base(path), ".json")
+	for i, stored := range ids {
+		if stored == id && i > 0 {
+			return filepath.Join(dir, ids[i-1]+".json"), nil
+		}
+	}
+	return "", fmt.Errorf("No run stored in %s before %s, give a baseline", dir, id)
+}
+
+// printComparison prints the findings and returns 1 if any of them is a
+// regression
+func printComparison(baselinePath string, runPath string, findings []Finding) int {
+	color.Cyan("## Comparing %s with baseline %s", runPath, baselinePath)
+	status := 0
+	for _, finding := range findings {
+		if finding.Regression {
+			color.Red("REGRESSION %s: %s", finding.Test, finding.Message)
+			status = 1
+		} else {
+			fmt.Printf("%s: %s\n", finding.Test, finding.Message)
+		}
+	}
+	if status == 0 {
+		color.Green("## No regressions")
+	}
+	return status
+}
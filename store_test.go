@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kipfs-runs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	results := exampleResults()
+	results[0].Revision = "ipfs/go-ipfs:v0.4.11"
+	ids := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		id, err := saveRun(dir, results)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if ids[0] == ids[1] || ids[1] == ids[2] {
+		t.Fatalf("Expected distinct ids, got %v", ids)
+	}
+	stored, err := listRuns(dir)
+	if err != nil || len(stored) != 3 {
+		t.Fatalf("Expected 3 stored runs, got %v (%v)", stored, err)
+	}
+	for run, id := range map[string]string{"latest": stored[2], "latest~2": stored[0], ids[1]: ids[1]} {
+		path, err := resolveRun(dir, run)
+		if err != nil || path != filepath.Join(dir, id+".json") {
+			t.Errorf("Expected %s to resolve to %s, got %s (%v)", run, id, path, err)
+		}
+	}
+	for _, run := range []string{"latest~3", "latest2", "missing"} {
+		if _, err := resolveRun(dir, run); err == nil {
+			t.Errorf("Expected %s not to resolve", run)
+		}
+	}
+	previous, err := previousRun(dir, filepath.Join(dir, stored[1]+".json"))
+	if err != nil || previous != filepath.Join(dir, stored[0]+".json") {
+		t.Errorf("Unexpected previous run %s (%v)", previous, err)
+	}
+	report, err := loadRun(filepath.Join(dir, ids[0]+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Tests) != 2 || report.Tests[0].Revision != "ipfs/go-ipfs:v0.4.11" || report.Tests[0].Summary.Timings[0].P50 != 1 {
+		t.Errorf("Unexpected stored report %+v", report)
+	}
+}
+
+func TestListRunsOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kipfs-runs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, id := range []string{"20171010T101010-10", "20171010T101010", "20171010T101010-9", "20171009T101010",
+		"baseline", "20171010T101010-x", "notes-2017"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, id+".json"), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids, err := listRuns(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ids, " ") != "20171009T101010 20171010T101010 20171010T101010-9 20171010T101010-10" {
+		t.Errorf("Unexpected order %v", ids)
+	}
+	/* Other files are not runs, but may still be given by name */
+	if path, err := resolveRun(dir, "latest"); err != nil || filepath.Base(path) != "20171010T101010-10.json" {
+		t.Errorf("Unexpected latest run %s (%v)", path, err)
+	}
+	if path, err := resolveRun(dir, "baseline"); err != nil || filepath.Base(path) != "baseline.json" {
+		t.Errorf("Unexpected baseline %s (%v)", path, err)
+	}
+}
+
+func TestCompareRuns(t *testing.T) {
+	summary := func(failures int, p50 float64) *Summary {
+		s := &Summary{}
+		s.addStepResult("steps", StepResult{Name: "cat", Successes: 4, Failures: failures})
+		s.addDurations("steps", "cat", seconds(p50, p50))
+		return s
+	}
+	baseline := Report{Tests: []ReportTest{
+		{Name: "add", Matrix: Params{"N": "3"}, Revision: "v1", Status: "pass", Summary: summary(0, 1)},
+		{Name: "add", Matrix: Params{"N": "5"}, Status: "pass", Summary: summary(0, 1)},
+		{Name: "pin", Status: "fail"},
+		{Name: "gone", Status: "pass"},
+	}}
+	run := Report{Tests: []ReportTest{
+		{Name: "add", Matrix: Params{"N": "3"}, Revision: "v2", Status: "pass", Summary: summary(0, 1.1)},
+		{Name: "add", Matrix: Params{"N": "5"}, Status: "fail", Summary: summary(2, 1.5)},
+		{Name: "pin", Status: "pass"},
+		{Name: "new", Status: "error", Error: "invalid"},
+	}}
+	findings := compareRuns(baseline, run, 20)
+	messages := make([]string, 0)
+	regressions := 0
+	for _, finding := range findings {
+		messages = append(messages, finding.Test+": "+finding.Message)
+		if finding.Regression {
+			regressions++
+		}
+	}
+	expected := []string{
+		"add (N=3): revision v1 -> v2",
+		"add (N=5): now fails",
+		"add (N=5): steps step 'cat': 2 failures, 0 before",
+		"add (N=5): steps step 'cat': p50 1.500s, 1.000s before (+50%)",
+		"add (N=5): steps step 'cat': p95 1.500s, 1.000s before (+50%)",
+		"pin: now passes, failed before",
+		"new: new test, error",
+		"gone: not run",
+	}
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Unexpected findings:\n%s", strings.Join(messages, "\n"))
+	}
+	if regressions != 5 {
+		t.Errorf("Expected 5 regressions, got %d", regressions)
+	}
+}
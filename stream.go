@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// streaming prints the output of commands line by line as it arrives,
+// set by --stream
+var streaming = false
+
+/* Keeps lines of nodes running in parallel from interleaving */
+var streamMutex sync.Mutex
+
+// lineWriter writes every complete line written to it to out, after a
+// prefix.  A partial line is held until it is completed or flushed.
+type lineWriter struct {
+	prefix  string
+	out     io.Writer
+	partial []byte
+}
+
+// streamWriters return the writers streaming the output and the errors of
+// the pod of a node.  Errors are marked so that they stand out.
+func streamWriters(node int, name string) (*lineWriter, *lineWriter) {
+	return &lineWriter{prefix: fmt.Sprintf("[%d %s] ", node, name), out: os.Stdout},
+		&lineWriter{prefix: fmt.Sprintf("[%d %s stderr] ", node, name), out: os.Stdout}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		end := bytes.IndexByte(w.partial, '\n')
+		if end < 0 {
+			break
+		}
+		w.writeLine(w.partial[:end])
+		w.partial = w.partial[end+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes the partial line, if any
+func (w *lineWriter) Flush() {
+	if len(w.partial) != 0 {
+		w.writeLine(w.partial)
+		w.partial = nil
+	}
+}
+
+func (w *lineWriter) writeLine(line []byte) {
+	/* kubectl exec -t ends lines with \r\n */
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	streamMutex.Lock()
+	defer streamMutex.Unlock()
+	fmt.Fprintf(w.out, "%s%s\n", w.prefix, line)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLineWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := &lineWriter{prefix: "[2 ipfs-1] ", out: &out}
+	for _, chunk := range []string{"added Qm", "abc file\r\n", "line 2\nline", " 3"} {
+		if n, err := w.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+		}
+	}
+	if out.String() != "[2 ipfs-1] added Qmabc file\n[2 ipfs-1] line 2\n" {
+		t.Fatalf("Unexpected output before flush %q", out.String())
+	}
+	w.Flush()
+	w.Flush()
+	if out.String() != "[2 ipfs-1] added Qmabc file\n[2 ipfs-1] line 2\n[2 ipfs-1] line 3\n" {
+		t.Errorf("Unexpected output after flush %q", out.String())
+	}
+}
+
+func TestStreamWriters(t *testing.T) {
+	stdout, stderr := streamWriters(3, "ipfs-1")
+	if stdout.prefix != "[3 ipfs-1] " || stderr.prefix != "[3 ipfs-1 stderr] " {
+		t.Errorf("Unexpected prefixes %q and %q", stdout.prefix, stderr.prefix)
+	}
+}
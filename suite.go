@@ -0,0 +1,365 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// stringList is a flag that can be passed several times or given a
+// comma separated list of values
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(input string) error {
+	for _, value := range strings.Split(input, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			*list = append(*list, value)
+		}
+	}
+	return nil
+}
+
+// suiteOptions select which tests of a suite are run
+type suiteOptions struct {
+	include     stringList
+	exclude     stringList
+	tags        stringList
+	excludeTags stringList
+	matrix      Params /* Only run matrix combinations with these values */
+	seed        int64  /* Overrides the seed of every test if not 0 */
+	dryRun      bool   /* Print the plan of each test instead of running it */
+	report      string /* File to write the JSON report to */
+	pushgateway string /* Pushgateway to push the outcome of each test to */
+	metricsAddr string /* Address to serve the outcomes of tests on */
+	store       string /* Directory to store the report of the run in */
+	revision    string /* Revision of the image, instead of its name */
+}
+
+func (opts *suiteOptions) register(flags *flag.FlagSet) {
+	flags.Var(&opts.include, "include",
+		"Only run test files whose path or name matches `<glob>`. May be repeated.")
+	flags.Var(&opts.exclude, "exclude",
+		"Skip test files whose path or name matches `<glob>`. May be repeated.")
+	flags.Var(&opts.tags, "tags",
+		"Only run tests that have at least one of the comma separated `<tags>`.")
+	flags.Var(&opts.excludeTags, "exclude-tags",
+		"Skip tests that have any of the comma separated `<tags>`.")
+}
+
+// RunResult is the outcome of one test run of a suite
+type RunResult struct {
+	File     string
+	Name     string
+	Matrix   Params /* Values of the swept parameters for this run */
+	Seed     int64
+	Revision string /* Of the image the test ran against */
+	Summary  Summary
+	Status   int   /* Exit status the test would have on its own */
+	Err      error /* Set if the test could not be loaded or validated */
+}
+
+func (result RunResult) passed() bool {
+	return result.Err == nil && result.Status == 0
+}
+
+type suiteTest struct {
+	result *RunResult
+	test   Test
+}
+
+// runSuite runs every test found in paths on a single scaled cluster,
+// continuing after failures, and returns the overall exit status
+func runSuite(paths []string, opts suiteOptions, sources paramSources) int {
+	files, err := collectTestFiles(paths)
+	if err != nil {
+		fatal(err)
+	}
+
+	results := make([]*RunResult, 0)
+	tests := make([]suiteTest, 0)
+	for _, file := range files {
+		if !opts.matchesFile(file) {
+			debug("Skipping " + file + " (filtered)")
+			continue
+		}
+		testConfig, err := loadTestConfig(file, sources)
+		if err != nil {
+			color.Red("## Could not load %s: %s", file, err)
+			results = append(results, &RunResult{File: file, Err: err})
+			continue
+		}
+		combos, err := testConfig.combinations(opts.matrix)
+		if err != nil {
+			color.Red("## Could not load %s: %s", file, err)
+			results = append(results, &RunResult{File: file, Err: err})
+			continue
+		}
+		for _, combo := range combos {
+			result := &RunResult{File: file, Matrix: combo}
+			test, _, err := loadSuiteTest(file, testConfig.withParams(combo), opts.seed)
+			result.Name = test.Name
+			result.Seed = test.Config.Seed
+			if err != nil {
+				color.Red("## Could not load %s %s: %s", file, combo.label(), err)
+				result.Err = err
+				results = append(results, result)
+				continue
+			}
+			if !opts.matchesTags(test.Tags) {
+				debug("Skipping " + file + " (tags)")
+				continue
+			}
+			results = append(results, result)
+			tests = append(tests, suiteTest{result, test})
+		}
+	}
+	if len(results) == 0 {
+		fatal("No tests to run")
+	}
+	if opts.dryRun {
+		for _, t := range tests {
+			/* Partition again so that each plan starts from its seed */
+			subsetPartition, _ := seededPartition(t.test.Config)
+			printPlan(t.test, subsetPartition)
+		}
+		for _, result := range results {
+			if result.Err != nil {
+				return 1
+			}
+		}
+		return 0
+	}
+
+	metrics := newExporter(opts.pushgateway, opts.metricsAddr)
+	for _, result := range results {
+		if result.Err != nil {
+			metrics.export(result)
+		}
+	}
+
+	/* All tests using a selector share one pod list, scaled once to
+	   the largest number of nodes any of them needs */
+	clusters := make(map[string]*Config)
+	for _, t := range tests {
+		cfg, ok := clusters[t.test.Config.Selector]
+		if !ok {
+			cfg = &Config{Selector: t.test.Config.Selector}
+			clusters[t.test.Config.Selector] = cfg
+		}
+		if t.test.Config.Nodes > cfg.Nodes {
+			cfg.Nodes = t.test.Config.Nodes
+		}
+	}
+	pods := make(map[string]*GetPodsOutput)
+	podsErrs := make(map[string]error)
+	for selector, cfg := range clusters {
+		color.Cyan("## Preparing %d nodes for selector %s", cfg.Nodes, selector)
+		if pods[selector], err = preparePods(cfg); err != nil {
+			color.Red("## Could not prepare the nodes for selector %s: %s", selector, err)
+			podsErrs[selector] = err
+		}
+	}
+
+	for _, t := range tests {
+		if err := checkInterrupted(); err != nil {
+			t.result.Err = err
+			metrics.export(t.result)
+			continue
+		}
+		color.Cyan("## Running %s %s", t.result.File, t.result.Matrix.label())
+		if err := podsErrs[t.test.Config.Selector]; err != nil {
+			t.result.Err = err
+			metrics.export(t.result)
+			continue
+		}
+		selectorPods := pods[t.test.Config.Selector]
+		/* Other tests were loaded since this one was partitioned, start
+		   again from its seed so that it selects the nodes it would alone */
+		subsetPartition, _ := seededPartition(t.test.Config)
+		metrics.start(t.result)
+		summary, err := runTest(t.test, subsetPartition, func() (*GetPodsOutput, error) {
+			return selectorPods, nil
+		})
+		if err != nil {
+			color.Red("## Test stopped: %s", err)
+			t.result.Err = err
+		}
+		t.result.Status = finishTest(&summary, t.test)
+		t.result.Summary = summary
+		t.result.Revision = runRevision(opts.revision, summary)
+		metrics.export(t.result)
+	}
+
+	status := printSuiteSummary(results)
+	if opts.report != "" {
+		if err := writeReport(opts.report, results); err != nil {
+			fatal(err)
+		}
+	}
+	storeRun(opts.store, results)
+	if checkInterrupted() != nil {
+		return 130
+	}
+	return status
+}
+
+func loadSuiteTest(file string, testConfig TestConfig, seed int64) (Test, map[int][]int, error) {
+	test, err := loadTest(file, testConfig)
+	if err != nil {
+		return test, nil, err
+	}
+	test.Config.Seed = resolveSeed(test.Config.Seed, seed)
+	subsetPartition, err := seededPartition(test.Config)
+	if err != nil {
+		return test, nil, err
+	}
+	if err := validate(test, subsetPartition); err != nil {
+		return test, nil, err
+	}
+	return test, subsetPartition, nil
+}
+
+// topLevelKeyRegex matches the keys of a YAML file that are not indented
+var topLevelKeyRegex = regexp.MustCompile(`(?m)^([A-Za-z_]+):`)
+
+// isTestFile tells whether a YAML file found in a directory holds a test
+// rather than macros, parameters or kubernetes manifests: a test has one
+// of the phases at its top level.  Keys are found by looking at the text,
+// since a test only parses once its parameters are replaced.
+func isTestFile(path string) (bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	for _, match := range topLevelKeyRegex.FindAllStringSubmatch(string(data), -1) {
+		switch match[1] {
+		case "setup", "steps", "teardown", "finally":
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// collectTestFiles expands directories into the sorted list of test
+// files they contain.  Other YAML files in directories, like config.yml
+// files, macro libraries and manifests, are skipped.  Files given by path
+// are always taken.
+func collectTestFiles(paths []string) ([]string, error) {
+	files := make([]string, 0)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		dirFiles := make([]string, 0)
+		err = filepath.Walk(path, func(file string, f os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			ext := filepath.Ext(file)
+			if f.IsDir() || (ext != ".yml" && ext != ".yaml") || f.Name() == "config.yml" {
+				return nil
+			}
+			isTest, err := isTestFile(file)
+			if err != nil {
+				return err
+			}
+			if !isTest {
+				debug("Skipping " + file + " (not a test)")
+				return nil
+			}
+			dirFiles = append(dirFiles, file)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(dirFiles)
+		files = append(files, dirFiles...)
+	}
+	return files, nil
+}
+
+func matchesAny(patterns []string, file string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, file); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, filepath.Base(file)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (opts suiteOptions) matchesFile(file string) bool {
+	if len(opts.include) != 0 && !matchesAny(opts.include, file) {
+		return false
+	}
+	return !matchesAny(opts.exclude, file)
+}
+
+func (opts suiteOptions) matchesTags(tags []string) bool {
+	for _, tag := range tags {
+		if containsString(opts.excludeTags, tag) {
+			return false
+		}
+	}
+	if len(opts.tags) == 0 {
+		return true
+	}
+	for _, tag := range tags {
+		if containsString(opts.tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// printSuiteSummary prints one line per test and returns 0 only if
+// every test met its expectations
+func printSuiteSummary(results []*RunResult) int {
+	passed := 0
+	fmt.Println("============================")
+	fmt.Println("== Suite Summary")
+	fmt.Println("===============")
+	fmt.Println("==")
+	for _, result := range results {
+		file := result.File
+		if len(result.Matrix) != 0 {
+			file += " [" + result.Matrix.label() + "]"
+		}
+		switch {
+		case result.Err != nil:
+			color.Red("== ERROR %s: %s", file, result.Err)
+		case result.passed():
+			passed++
+			color.Green("== PASS  %s (%s) %d/%d (success/failure), %d timeouts",
+				result.Name, file, result.Summary.Successes, result.Summary.Failures, result.Summary.Timeouts)
+		default:
+			color.Red("== FAIL  %s (%s) %d/%d (success/failure), %d timeouts",
+				result.Name, file, result.Summary.Successes, result.Summary.Failures, result.Summary.Timeouts)
+		}
+	}
+	fmt.Println("==")
+	fmt.Printf("== Passed: %d/%d\n", passed, len(results))
+	if passed != len(results) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// test that directories expand into sorted test files without config files
+func TestCollectTestFiles(t *testing.T) {
+	files, err := collectTestFiles([]string{"ipfs-cluster/tests", "tests/simple-add-and-cat.yml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 16 {
+		t.Fatalf("Expected 16 test files, got %d: %v", len(files), files)
+	}
+	if files[0] != "ipfs-cluster/tests/0001-start_and_check.yml" || files[15] != "tests/simple-add-and-cat.yml" {
+		t.Fatalf("Test files not in order: %v", files)
+	}
+	for _, file := range files {
+		if file == "ipfs-cluster/tests/config.yml" {
+			t.Fatal("config.yml should not be collected as a test")
+		}
+	}
+	if _, err := collectTestFiles([]string{"does-not-exist"}); err == nil {
+		t.Fatal("Missing path should fail")
+	}
+}
+
+// test that libraries and manifests in a directory are not taken for tests
+func TestCollectSkipsOtherYAML(t *testing.T) {
+	files, err := collectTestFiles([]string{"ipfs-cluster"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 15 {
+		t.Fatalf("Expected the 15 tests of ipfs-cluster/tests, got %d: %v", len(files), files)
+	}
+	for _, file := range files {
+		if filepath.Dir(file) != "ipfs-cluster/tests" {
+			t.Errorf("%s should not be collected as a test", file)
+		}
+	}
+	/* A file given by path is always a test */
+	files, err = collectTestFiles([]string{"ipfs-cluster/lib/cluster-steps.yml"})
+	if err != nil || len(files) != 1 {
+		t.Errorf("Expected the file given by path, got %v (%v)", files, err)
+	}
+}
+
+// test that include/exclude globs and tags filter tests
+func TestSuiteFilters(t *testing.T) {
+	var opts suiteOptions
+	opts.include.Set("0007-*,*/0008-block_majority.yml")
+	opts.exclude.Set("*majority*")
+	if !opts.matchesFile("ipfs-cluster/tests/0007-block_minority.yml") {
+		t.Error("0007 should match the include glob")
+	}
+	if opts.matchesFile("ipfs-cluster/tests/0008-block_majority.yml") {
+		t.Error("0008 should be excluded")
+	}
+	if opts.matchesFile("ipfs-cluster/tests/0009-replication_self_heal.yml") {
+		t.Error("0009 should not match any include glob")
+	}
+
+	opts.tags.Set("cluster")
+	opts.excludeTags.Set("slow")
+	if !opts.matchesTags([]string{"cluster", "pin"}) {
+		t.Error("Tagged test should match")
+	}
+	if opts.matchesTags([]string{"cluster", "slow"}) {
+		t.Error("Test with an excluded tag should not match")
+	}
+	if opts.matchesTags(nil) {
+		t.Error("Untagged test should not match when tags are required")
+	}
+}
+
+// test that the suite exit status reflects every result
+func TestSuiteSummaryStatus(t *testing.T) {
+	pass := &RunResult{File: "a.yml", Name: "a"}
+	fail := &RunResult{File: "b.yml", Name: "b", Status: 1}
+	if printSuiteSummary([]*RunResult{pass}) != 0 {
+		t.Error("Suite with only passing tests should succeed")
+	}
+	if printSuiteSummary([]*RunResult{pass, fail}) != 1 {
+		t.Error("Suite with a failing test should fail")
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// StepTiming holds the durations of the executions of a step on every
+// node, over all of its iterations and repetitions.  Executions that timed
+// out are left out.
+type StepTiming struct {
+	Phase     string          `json:"phase"`
+	Step      string          `json:"step"`
+	Count     int             `json:"count"`
+	Min       float64         `json:"min_seconds"`
+	P50       float64         `json:"p50_seconds"`
+	P90       float64         `json:"p90_seconds"`
+	P95       float64         `json:"p95_seconds"`
+	P99       float64         `json:"p99_seconds"`
+	Max       float64         `json:"max_seconds"`
+	durations []time.Duration /* Sorted */
+}
+
+// percentile returns the nearest-rank percentile of sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func (timing *StepTiming) add(durations []time.Duration) {
+	timing.durations = append(timing.durations, durations...)
+	sort.Slice(timing.durations, func(i, j int) bool { return timing.durations[i] < timing.durations[j] })
+	sorted := timing.durations
+	timing.Count = len(sorted)
+	if timing.Count == 0 {
+		return
+	}
+	timing.Min = sorted[0].Seconds()
+	timing.P50 = percentile(sorted, 50).Seconds()
+	timing.P90 = percentile(sorted, 90).Seconds()
+	timing.P95 = percentile(sorted, 95).Seconds()
+	timing.P99 = percentile(sorted, 99).Seconds()
+	timing.Max = sorted[len(sorted)-1].Seconds()
+}
+
+// addDurations adds the durations of executions of a step to its timing
+func (summary *Summary) addDurations(phase string, step string, durations []time.Duration) {
+	if len(durations) == 0 {
+		return
+	}
+	for i := range summary.Timings {
+		if summary.Timings[i].Phase == phase && summary.Timings[i].Step == step {
+			summary.Timings[i].add(durations)
+			return
+		}
+	}
+	timing := StepTiming{Phase: phase, Step: step}
+	timing.add(durations)
+	summary.Timings = append(summary.Timings, timing)
+}
+
+// parseDurationBound parses a max_duration or p95_below bound
+func parseDurationBound(name string, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	bound, err := time.ParseDuration(value)
+	if err != nil || bound <= 0 {
+		return 0, fmt.Errorf("Invalid %s %s, expected a positive duration like 30s", name, value)
+	}
+	return bound, nil
+}
+
+// validateDurationBounds checks the duration assertions of a step
+func (step Step) validateDurationBounds() error {
+	if step.MaxDuration == "" && step.P95Below == "" {
+		return nil
+	}
+	if step.CMD == "" || step.Generate != nil || step.Network != nil || step.Chaos != nil {
+		return errors.New("Duration assertions are only valid on command steps")
+	}
+	if _, err := parseDurationBound("max_duration", step.MaxDuration); err != nil {
+		return err
+	}
+	_, err := parseDurationBound("p95_below", step.P95Below)
+	return err
+}
+
+// assertDurations checks the durations of the executions of a step
+// iteration against its max_duration and p95_below, each of which counts
+// as an assertion
+func assertDurations(step *Step, summary *Summary, durations []time.Duration) {
+	if step.MaxDuration == "" && step.P95Below == "" {
+		return
+	}
+	if len(durations) == 0 {
+		color.Yellow("### No execution of step %s completed, skipping duration assertions", step.Name)
+		return
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	check := func(passed bool, description string) {
+		if !passed {
+			color.Red("Assertion failed! %s", description)
+			summary.Failures++
+			return
+		}
+		color.Green("Assertion Passed (%s)", description)
+		summary.Successes++
+	}
+	if bound, _ := parseDurationBound("max_duration", step.MaxDuration); bound != 0 {
+		longest := sorted[len(sorted)-1]
+		check(longest <= bound, fmt.Sprintf("max duration %s, at most %s", longest, bound))
+	}
+	if bound, _ := parseDurationBound("p95_below", step.P95Below); bound != 0 {
+		p95 := percentile(sorted, 95)
+		check(p95 < bound, fmt.Sprintf("p95 duration %s, below %s", p95, bound))
+	}
+}
+
+func printTimings(timings []StepTiming) {
+	if len(timings) == 0 {
+		return
+	}
+	fmt.Println("== Durations in seconds (count min/p50/p90/p99/max):")
+	for _, timing := range timings {
+		fmt.Printf("==   %s, step '%s': %d %.3f/%.3f/%.3f/%.3f/%.3f\n", timing.Phase, timing.Step, timing.Count,
+			timing.Min, timing.P50, timing.P90, timing.P99, timing.Max)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func seconds(values ...float64) []time.Duration {
+	durations := make([]time.Duration, 0, len(values))
+	for _, value := range values {
+		durations = append(durations, time.Duration(value*float64(time.Second)))
+	}
+	return durations
+}
+
+func TestStepTimings(t *testing.T) {
+	var summary Summary
+	summary.addDurations("steps", "cat", seconds(5, 1, 3))
+	summary.addDurations("steps", "add", seconds(2))
+	summary.addDurations("steps", "cat", seconds(2, 4))
+	summary.addDurations("steps", "none", nil)
+	if len(summary.Timings) != 2 {
+		t.Fatalf("Unexpected timings %+v", summary.Timings)
+	}
+	cat := summary.Timings[0]
+	if cat.Step != "cat" || cat.Count != 5 || cat.Min != 1 || cat.P50 != 3 || cat.P90 != 5 || cat.P99 != 5 || cat.Max != 5 {
+		t.Fatalf("Unexpected timing %+v", cat)
+	}
+
+	/* Nearest rank over 100 values */
+	values := make([]float64, 0, 100)
+	for i := 100; i >= 1; i-- {
+		values = append(values, float64(i))
+	}
+	summary.addDurations("setup", "load", seconds(values...))
+	load := summary.Timings[2]
+	if load.P50 != 50 || load.P90 != 90 || load.P95 != 95 || load.P99 != 99 || load.Max != 100 {
+		t.Fatalf("Unexpected percentiles %+v", load)
+	}
+}
+
+func TestAssertDurations(t *testing.T) {
+	for _, c := range []struct {
+		step      Step
+		durations []time.Duration
+		successes int
+		failures  int
+	}{
+		{Step{MaxDuration: "5s"}, seconds(1, 5), 1, 0},
+		{Step{MaxDuration: "5s"}, seconds(1, 5.5), 0, 1},
+		{Step{P95Below: "2s"}, seconds(1, 1, 1.5), 1, 0},
+		{Step{P95Below: "2s"}, seconds(1, 2), 0, 1},
+		{Step{MaxDuration: "10s", P95Below: "2s"}, seconds(1, 3), 1, 1},
+		{Step{MaxDuration: "10s"}, nil, 0, 0},
+		{Step{}, seconds(100), 0, 0},
+	} {
+		var summary Summary
+		assertDurations(&c.step, &summary, c.durations)
+		if summary.Successes != c.successes || summary.Failures != c.failures {
+			t.Errorf("%+v on %v: expected %d/%d, got %d/%d", c.step, c.durations,
+				c.successes, c.failures, summary.Successes, summary.Failures)
+		}
+	}
+}
+
+func TestValidateDurationBounds(t *testing.T) {
+	for _, c := range []struct {
+		step    Step
+		message string
+	}{
+		{Step{CMD: "ipfs cat $HASH", MaxDuration: "1m", P95Below: "1.5s"}, ""},
+		{Step{CMD: "ipfs cat $HASH"}, ""},
+		{Step{CMD: "ipfs cat $HASH", MaxDuration: "10"}, "Invalid max_duration"},
+		{Step{CMD: "ipfs cat $HASH", P95Below: "-1s"}, "Invalid p95_below"},
+		{Step{Generate: &Generate{}, MaxDuration: "1s"}, "only valid on command steps"},
+		{Step{HealNetwork: true, P95Below: "1s"}, "only valid on command steps"},
+	} {
+		err := c.step.validateDurationBounds()
+		checkError(t, c.step, err, c.message)
+	}
+}
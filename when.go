@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// stepScope exposes the runner state to `when:` expressions: saved
+// variables, arrays (indexed by the current iteration with %i) and the
+// results of the previous step as previous.successes, previous.failures,
+// previous.timeouts and previous.skipped.  Unset variables and out of
+// range elements evaluate to "", just like in bash.
+type stepScope struct {
+	env       []string
+	envArrays map[string][]string
+	iter      int
+	previous  StepResult
+}
+
+func (scope stepScope) variable(name string) (string, error) {
+	if strings.HasPrefix(name, "previous.") {
+		switch strings.TrimPrefix(name, "previous.") {
+		case "successes":
+			return strconv.Itoa(scope.previous.Successes), nil
+		case "failures":
+			return strconv.Itoa(scope.previous.Failures), nil
+		case "timeouts":
+			return strconv.Itoa(scope.previous.Timeouts), nil
+		case "skipped":
+			return strconv.Itoa(scope.previous.Skipped), nil
+		}
+		return "", fmt.Errorf("unknown step result %s", name)
+	}
+	value, _ := envValue(scope.env, name)
+	return value, nil
+}
+
+func (scope stepScope) element(name string, index int) (string, error) {
+	array := scope.envArrays[name]
+	if index < 0 || index >= len(array) {
+		return "", nil
+	}
+	return array[index], nil
+}
+
+func (scope stepScope) length(name string) (int, error) {
+	return len(scope.envArrays[name]), nil
+}
+
+func (scope stepScope) iteration() int {
+	return scope.iter
+}
+
+func evalWhen(when string, scope exprScope) (bool, error) {
+	node, err := parseExpr(when)
+	if err != nil {
+		return false, err
+	}
+	value, err := node.eval(scope)
+	if err != nil {
+		return false, err
+	}
+	return exprTruthy(value), nil
+}
+
+func validateWhen(steps []Step) error {
+	for idx, step := range steps {
+		if step.When == "" {
+			continue
+		}
+		if _, err := parseExpr(step.When); err != nil {
+			return validateError(idx, "Invalid when expression: "+err.Error())
+		}
+	}
+	return nil
+}
+
+// envValue returns the value most recently saved to a variable with
+// save_to; later assignments win, as they do in the generated bash
+func envValue(env []string, name string) (string, bool) {
+	prefix := name + "=\""
+	for i := len(env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(env[i], prefix) && strings.HasSuffix(env[i], "\"") {
+			return env[i][len(prefix) : len(env[i])-1], true
+		}
+	}
+	return "", false
+}
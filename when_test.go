@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+)
+
+// test that when expressions see saved variables, arrays and the previous step
+func TestEvalWhen(t *testing.T) {
+	scope := stepScope{
+		env:       []string{"HASH=\"QmOld\"", "HASH=\"QmNew\""},
+		envArrays: map[string][]string{"SUCCESS": {"yes", "no"}},
+		iter:      1,
+		previous:  StepResult{Name: "pin", Failures: 2},
+	}
+	cases := map[string]bool{
+		"HASH == 'QmNew'":          true,
+		"SUCCESS[%i] == 'yes'":     false,
+		"SUCCESS[%i - 1] == 'yes'": true,
+		"previous.failures > 0":    true,
+		"previous.timeouts":        false,
+		"UNSET":                    false,
+	}
+	for when, want := range cases {
+		got, err := evalWhen(when, scope)
+		if err != nil {
+			t.Fatalf("Failed to evaluate %q: %s", when, err)
+		}
+		if got != want {
+			t.Errorf("%q evaluated to %v, expected %v", when, got, want)
+		}
+	}
+	if _, err := evalWhen("previous.bogus", scope); err == nil {
+		t.Error("Unknown step result field should fail")
+	}
+}
+
+// test that invalid when expressions fail validation
+func TestValidateWhen(t *testing.T) {
+	steps := []Step{{Name: "ok", When: "N > 1"}, {Name: "bad", When: "N >"}}
+	if err := validateWhen(steps); err == nil {
+		t.Fatal("Invalid when expression should fail validation")
+	}
+}